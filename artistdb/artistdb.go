@@ -0,0 +1,209 @@
+package artistdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"social-2-telego/database"
+	"social-2-telego/utils"
+)
+
+const (
+	// How long a found artist is trusted before being looked up again
+	cacheTTL = 24 * time.Hour
+	// The same for a handle ArtistDB has no entry for, shorter so new
+	// entries show up soon
+	missingCacheTTL = time.Hour
+	// How long a lookup may take before the scraped handle is used instead
+	requestTimeout = 10 * time.Second
+)
+
+// A new entry sent to the ArtistDB API, as a suggestion its admins review
+// or as an entry of its own
+type apiStub struct {
+	Source      string `json:"source"`
+	Handle      string `json:"handle"`
+	ProfileURL  string `json:"profile_url"`
+	DisplayName string `json:"display_name"`
+	Suggestion  bool   `json:"suggestion"`
+}
+
+// An entry as returned by the ArtistDB API
+type apiArtist struct {
+	Username    string   `json:"username"`
+	DisplayName string   `json:"display_name"`
+	Aliases     []string `json:"aliases"`
+}
+
+// Looks up the canonical identity of artists by their handle on a site,
+// caching the answers in the database
+type Client struct {
+	appState   *utils.AppState
+	db         *database.Database
+	httpClient *http.Client
+}
+
+// Create a new ArtistDB client
+func NewClient(appState *utils.AppState, db *database.Database) *Client {
+	return &Client{
+		appState:   appState,
+		db:         db,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Get the canonical entry of the artist having a handle on a site, like "fa".
+// Returns nil when ArtistDB has no entry for the handle or when ARTIST_DB_API
+// isn't set. A stale cached entry is used when ArtistDB can't be reached
+func (c *Client) Lookup(source string, handle string) (*database.Artist, error) {
+	if c.appState.GetArtistDBAPI() == "" {
+		return nil, nil
+	}
+
+	cached, err := c.db.GetCachedArtist(source, handle)
+	if err != nil {
+		slog.Warn("failed to read the artist cache", "err", err)
+	}
+	if cached != nil && time.Since(cached.CachedAt) < ttl(cached) {
+		return found(cached), nil
+	}
+
+	artist, err := c.fetch(source, handle)
+	if err == nil && cached != nil {
+		artist.Suggested = cached.Suggested
+	}
+	if err != nil {
+		if cached != nil {
+			slog.Warn("failed to look up the artist, using the cached entry", "handle", handle, "err", err)
+			return found(cached), nil
+		}
+		return nil, fmt.Errorf("ArtistDB.Lookup: %w", err)
+	}
+	if err := c.db.CacheArtist(source, handle, artist); err != nil {
+		slog.Warn("failed to cache the artist", "err", err)
+	}
+	return found(artist), nil
+}
+
+// Add an artist ArtistDB has no entry for, as ARTIST_DB_WRITE says. Returns
+// the created entry, nil when the artist was suggested instead, and whether
+// ArtistDB was written to, which it isn't twice for the same handle
+func (c *Client) Register(source string, handle string, profileURL string, displayName string) (*database.Artist, bool, error) {
+	write := c.appState.GetArtistDBWrite()
+	if c.appState.GetArtistDBAPI() == "" || write == "" {
+		return nil, false, nil
+	}
+	cached, err := c.db.GetCachedArtist(source, handle)
+	if err != nil {
+		slog.Warn("failed to read the artist cache", "err", err)
+	}
+	if cached != nil && (cached.Username != "" || cached.Suggested) {
+		return found(cached), false, nil
+	}
+
+	body, err := json.Marshal(apiStub{
+		Source:      source,
+		Handle:      handle,
+		ProfileURL:  profileURL,
+		DisplayName: displayName,
+		Suggestion:  write == "suggest",
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("ArtistDB.Register: %w", err)
+	}
+	req, err := http.NewRequest("POST", c.lookupURL(source, handle), bytes.NewReader(body))
+	if err != nil {
+		return nil, false, fmt.Errorf("ArtistDB.Register: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := c.appState.GetArtistDBToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("ArtistDB.Register: %w", err)
+	}
+	defer resp.Body.Close()
+
+	artist := &database.Artist{CachedAt: time.Now()}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		var entry apiArtist
+		if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+			return nil, false, fmt.Errorf("ArtistDB.Register: %w", err)
+		}
+		artist.Username = entry.Username
+		artist.DisplayName = entry.DisplayName
+		artist.Aliases = entry.Aliases
+	case http.StatusAccepted:
+		artist.Suggested = true
+	default:
+		return nil, false, fmt.Errorf("ArtistDB.Register: %w", utils.UnexpectedStatus(resp))
+	}
+	if err := c.db.CacheArtist(source, handle, artist); err != nil {
+		slog.Warn("failed to cache the artist", "err", err)
+	}
+	return found(artist), true, nil
+}
+
+// The URL of the entry of a handle on the ArtistDB API, handles are
+// case-insensitive on every supported site
+func (c *Client) lookupURL(source string, handle string) string {
+	return utils.ArtistLink(c.appState.GetArtistDBAPI(), strings.ToLower(handle), "", source)
+}
+
+// Ask ArtistDB for the entry of a handle, an artist without a username when
+// there's none
+func (c *Client) fetch(source string, handle string) (*database.Artist, error) {
+	req, err := http.NewRequest("GET", c.lookupURL(source, handle), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := c.appState.GetArtistDBToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	artist := &database.Artist{CachedAt: time.Now()}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return artist, nil
+	default:
+		return nil, utils.UnexpectedStatus(resp)
+	}
+
+	var entry apiArtist
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		return nil, err
+	}
+	artist.Username = entry.Username
+	artist.DisplayName = entry.DisplayName
+	artist.Aliases = entry.Aliases
+	return artist, nil
+}
+
+// How long a cached entry is trusted
+func ttl(artist *database.Artist) time.Duration {
+	if artist.Username == "" {
+		return missingCacheTTL
+	}
+	return cacheTTL
+}
+
+// The artist itself, or nil when it stands for a missing entry
+func found(artist *database.Artist) *database.Artist {
+	if artist.Username == "" {
+		return nil
+	}
+	return artist
+}
@@ -0,0 +1,102 @@
+package artistdb_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"social-2-telego/artistdb"
+	"social-2-telego/database"
+	"social-2-telego/utils"
+	"testing"
+)
+
+func newTestClient(t *testing.T, serverURL string) *artistdb.Client {
+	t.Setenv("BOT_TOKEN", "token")
+	t.Setenv("ARTIST_DB_DOMAIN", "https://artistdb.example.com/{username}")
+	t.Setenv("ARTIST_DB_API", serverURL+"/api/{source}/{username}")
+	db, err := database.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return artistdb.NewClient(utils.NewAppState(), db)
+}
+
+func TestLookupCachesTheCanonicalArtist(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/api/fa/foo_art" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"username": "foo", "display_name": "Foo Bar", "aliases": ["fa:foo_art", "x:foo"]}`)
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server.URL)
+
+	for range 2 {
+		artist, err := client.Lookup("fa", "Foo_Art")
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if artist == nil || artist.AuthorInfo() != "@foo Foo Bar" || len(artist.Aliases) != 2 {
+			t.Fatalf("Unexpected artist %+v", artist)
+		}
+	}
+	for range 2 {
+		if artist, err := client.Lookup("x", "nobody"); err != nil || artist != nil {
+			t.Fatalf("Expected no artist, got %+v, %v", artist, err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("Expected each handle to be looked up once, got %d requests", requests)
+	}
+}
+
+func TestRegisterCreatesAStubOnce(t *testing.T) {
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		posts++
+		var stub map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&stub); err != nil || r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Unexpected request %v, %v", stub, err)
+		}
+		if stub["profile_url"] != "https://x.com/Foo" || stub["suggestion"] != false {
+			t.Errorf("Unexpected stub %v", stub)
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"username": "foo", "display_name": "Foo"}`)
+	}))
+	defer server.Close()
+
+	t.Setenv("ARTIST_DB_WRITE", "create")
+	t.Setenv("ARTIST_DB_API_TOKEN", "secret")
+	client := newTestClient(t, server.URL)
+
+	if artist, err := client.Lookup("x", "Foo"); err != nil || artist != nil {
+		t.Fatalf("Expected no artist, got %+v, %v", artist, err)
+	}
+	for i := range 2 {
+		artist, registered, err := client.Register("x", "Foo", "https://x.com/Foo", "Foo")
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if artist == nil || artist.Username != "foo" || registered != (i == 0) {
+			t.Errorf("Unexpected artist %+v, registered %v", artist, registered)
+		}
+	}
+	if artist, err := client.Lookup("x", "foo"); err != nil || artist == nil {
+		t.Errorf("Expected the created artist to be cached, got %+v, %v", artist, err)
+	}
+	if posts != 1 {
+		t.Errorf("Expected one stub to be created, got %d", posts)
+	}
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Split a "source:handle" alias, false when it isn't one
+func SplitAlias(alias string) (string, string, bool) {
+	source, handle, ok := strings.Cut(alias, ":")
+	if !ok || source == "" || handle == "" {
+		return "", "", false
+	}
+	return strings.ToLower(source), strings.TrimPrefix(handle, "@"), true
+}
+
+// Add an artist to the local registry or replace it. The registry maps the
+// handles of an artist, its aliases like "fa:foo", to its canonical username
+// when ArtistDB has no entry or can't be reached. The aliases are added to the
+// ones the artist already has, an alias belonging to another artist is moved
+func (d *Database) SaveLocalArtist(artist *Artist) error {
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		aliases := tx.Bucket(aliasesBucket)
+		handles := tx.Bucket(handlesBucket)
+		key := []byte(strings.ToLower(artist.Username))
+
+		merged := *artist
+		if data := aliases.Get(key); data != nil {
+			existing := &Artist{}
+			if err := json.Unmarshal(data, existing); err != nil {
+				return err
+			}
+			merged.Aliases = append(existing.Aliases, artist.Aliases...)
+		}
+		merged.Aliases = normalizeAliases(merged.Aliases)
+
+		for _, alias := range merged.Aliases {
+			source, handle, _ := SplitAlias(alias)
+			owner := handles.Get(artistKey(source, handle))
+			if owner != nil && string(owner) != string(key) {
+				if err := removeAlias(tx, owner, alias); err != nil {
+					return err
+				}
+			}
+			if err := handles.Put(artistKey(source, handle), key); err != nil {
+				return err
+			}
+		}
+
+		data, err := json.Marshal(merged)
+		if err != nil {
+			return err
+		}
+		return aliases.Put(key, data)
+	}); err != nil {
+		return fmt.Errorf("Database.SaveLocalArtist: %w", err)
+	}
+	return nil
+}
+
+// Lowercase the aliases and drop the duplicates and the invalid ones
+func normalizeAliases(aliases []string) []string {
+	result := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		source, handle, ok := SplitAlias(alias)
+		if !ok {
+			continue
+		}
+		alias = strings.ToLower(source + ":" + handle)
+		if !slices.Contains(result, alias) {
+			result = append(result, alias)
+		}
+	}
+	return result
+}
+
+// Take an alias away from the artist owning it
+func removeAlias(tx *bolt.Tx, owner []byte, alias string) error {
+	data := tx.Bucket(aliasesBucket).Get(owner)
+	if data == nil {
+		return nil
+	}
+	artist := &Artist{}
+	if err := json.Unmarshal(data, artist); err != nil {
+		return err
+	}
+	artist.Aliases = slices.DeleteFunc(artist.Aliases, func(item string) bool { return item == alias })
+	data, err := json.Marshal(artist)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(aliasesBucket).Put(owner, data)
+}
+
+// Get an artist of the local registry by its username, nil when there's none
+func (d *Database) GetLocalArtist(username string) (*Artist, error) {
+	var artist *Artist
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(aliasesBucket).Get([]byte(strings.ToLower(username)))
+		if data == nil {
+			return nil
+		}
+		artist = &Artist{}
+		return json.Unmarshal(data, artist)
+	}); err != nil {
+		return nil, fmt.Errorf("Database.GetLocalArtist: %w", err)
+	}
+	return artist, nil
+}
+
+// Find the artist of a handle on a site in the local registry, nil when
+// there's none
+func (d *Database) FindLocalArtist(source string, handle string) (*Artist, error) {
+	var artist *Artist
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		owner := tx.Bucket(handlesBucket).Get(artistKey(source, handle))
+		if owner == nil {
+			return nil
+		}
+		data := tx.Bucket(aliasesBucket).Get(owner)
+		if data == nil {
+			return nil
+		}
+		artist = &Artist{}
+		return json.Unmarshal(data, artist)
+	}); err != nil {
+		return nil, fmt.Errorf("Database.FindLocalArtist: %w", err)
+	}
+	return artist, nil
+}
+
+// Remove an artist and its aliases from the local registry, false when it
+// wasn't there
+func (d *Database) DeleteLocalArtist(username string) (bool, error) {
+	deleted := false
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		key := []byte(strings.ToLower(username))
+		data := tx.Bucket(aliasesBucket).Get(key)
+		if data == nil {
+			return nil
+		}
+		artist := &Artist{}
+		if err := json.Unmarshal(data, artist); err != nil {
+			return err
+		}
+		for _, alias := range artist.Aliases {
+			source, handle, _ := SplitAlias(alias)
+			if err := tx.Bucket(handlesBucket).Delete(artistKey(source, handle)); err != nil {
+				return err
+			}
+		}
+		deleted = true
+		return tx.Bucket(aliasesBucket).Delete(key)
+	}); err != nil {
+		return false, fmt.Errorf("Database.DeleteLocalArtist: %w", err)
+	}
+	return deleted, nil
+}
+
+// List the artists of the local registry by username
+func (d *Database) ListLocalArtists() ([]*Artist, error) {
+	artists := make([]*Artist, 0)
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(aliasesBucket).ForEach(func(_, data []byte) error {
+			artist := &Artist{}
+			if err := json.Unmarshal(data, artist); err != nil {
+				return err
+			}
+			artists = append(artists, artist)
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("Database.ListLocalArtists: %w", err)
+	}
+	return artists, nil
+}
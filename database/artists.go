@@ -0,0 +1,72 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The canonical identity of an artist on ArtistDB, cached by the handle it
+// was looked up with
+type Artist struct {
+	// Empty when ArtistDB has no entry for the handle
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	// The handles of the artist on each site, like "fa:foo"
+	Aliases  []string  `json:"aliases"`
+	CachedAt time.Time `json:"cached_at"`
+	// The defaults of the posts of the artist, only in the local registry:
+	// the hashtags they always get, the target they go to instead of the
+	// routed ones and whether their media are hidden behind a spoiler
+	Hashtags []string `json:"hashtags,omitempty"`
+	Target   string   `json:"target,omitempty"`
+	Spoiler  bool     `json:"spoiler,omitempty"`
+	// Whether an entry was already suggested to ArtistDB for the handle
+	Suggested bool `json:"suggested,omitempty"`
+}
+
+// The credits of the artist as given in the input, "@username Display Name"
+func (a *Artist) AuthorInfo() string {
+	if a.DisplayName == "" {
+		return "@" + a.Username
+	}
+	return "@" + a.Username + " " + a.DisplayName
+}
+
+// The key of a cached artist, handles are case-insensitive
+func artistKey(source string, handle string) []byte {
+	return []byte(strings.ToLower(source + ":" + handle))
+}
+
+// Get the cached artist of a handle on a site, nil when it was never looked up
+func (d *Database) GetCachedArtist(source string, handle string) (*Artist, error) {
+	var artist *Artist
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(artistsBucket).Get(artistKey(source, handle))
+		if data == nil {
+			return nil
+		}
+		artist = &Artist{}
+		return json.Unmarshal(data, artist)
+	}); err != nil {
+		return nil, fmt.Errorf("Database.GetCachedArtist: %w", err)
+	}
+	return artist, nil
+}
+
+// Cache the artist of a handle on a site, replacing the previous lookup
+func (d *Database) CacheArtist(source string, handle string, artist *Artist) error {
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(artist)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(artistsBucket).Put(artistKey(source, handle), data)
+	}); err != nil {
+		return fmt.Errorf("Database.CacheArtist: %w", err)
+	}
+	return nil
+}
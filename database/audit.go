@@ -0,0 +1,81 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Only the latest attempts are kept, so a spammer can't fill the disk
+const maxAuditEntries = 1000
+
+// A message from a user who isn't allowed to use the bot
+type UnauthorizedAttempt struct {
+	ID       uint64    `json:"id"`
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	ChatID   int       `json:"chat_id"`
+	Text     string    `json:"text"`
+	At       time.Time `json:"at"`
+	// Whether the user was told they aren't allowed
+	Replied bool `json:"replied"`
+}
+
+// Save an attempt in the audit log, dropping the oldest ones past the limit.
+// Its ID is assigned here
+func (d *Database) LogUnauthorizedAttempt(attempt *UnauthorizedAttempt) error {
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(auditBucket)
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		attempt.ID = id
+
+		data, err := json.Marshal(attempt)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(itob(id), data); err != nil {
+			return err
+		}
+
+		// the IDs follow each other, everything below the limit goes
+		if id <= maxAuditEntries {
+			return nil
+		}
+		oldest := itob(id - maxAuditEntries)
+		cursor := bucket.Cursor()
+		for key, _ := cursor.First(); key != nil && bytes.Compare(key, oldest) <= 0; key, _ = cursor.First() {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.LogUnauthorizedAttempt: %w", err)
+	}
+	return nil
+}
+
+// List the latest attempts of the audit log, newest first
+func (d *Database) ListUnauthorizedAttempts(limit int) ([]*UnauthorizedAttempt, error) {
+	attempts := make([]*UnauthorizedAttempt, 0)
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(auditBucket).Cursor()
+		for key, data := cursor.Last(); key != nil && len(attempts) < limit; key, data = cursor.Prev() {
+			attempt := &UnauthorizedAttempt{}
+			if err := json.Unmarshal(data, attempt); err != nil {
+				return err
+			}
+			attempts = append(attempts, attempt)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("Database.ListUnauthorizedAttempts: %w", err)
+	}
+	return attempts, nil
+}
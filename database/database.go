@@ -0,0 +1,76 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	postsBucket    = []byte("posts")
+	postURLsBucket = []byte("post_urls")
+	jobsBucket     = []byte("jobs")
+	slotsBucket    = []byte("slots")
+	draftsBucket   = []byte("drafts")
+	artistsBucket  = []byte("artists")
+	aliasesBucket  = []byte("aliases")
+	handlesBucket  = []byte("alias_handles")
+	statusesBucket = []byte("statuses")
+	auditBucket    = []byte("audit")
+)
+
+// An embedded key-value store keeping everything that must survive restarts
+type Database struct {
+	db       *bolt.DB
+	jobAdded chan struct{}
+}
+
+// Open the database file, creating it and its buckets if needed
+func Open(path string) (*Database, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("database.Open: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("database.Open: %w", err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{postsBucket, postURLsBucket, jobsBucket, slotsBucket, draftsBucket, artistsBucket, aliasesBucket, handlesBucket, statusesBucket, auditBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("database.Open: %w", err)
+	}
+
+	return &Database{
+		db:       db,
+		jobAdded: make(chan struct{}, 1),
+	}, nil
+}
+
+// Close the database file
+func (d *Database) Close() error {
+	return d.db.Close()
+}
+
+// Encode a sequence number as a key sorting in insertion order
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// Decode a key made by itob
+func btoi(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
@@ -0,0 +1,333 @@
+package database_test
+
+import (
+	"path/filepath"
+	"social-2-telego/database"
+	"social-2-telego/social"
+	"social-2-telego/utils"
+	"testing"
+	"time"
+)
+
+func openTestDatabase(t *testing.T) *database.Database {
+	db, err := database.Open(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestJobsAreClaimedInOrderAndResumed(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if err := db.EnqueueJobs([]utils.IncomingMessage{{Text: "first"}, {Text: "second"}}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	job, err := db.ClaimJob()
+	if err != nil || job == nil || job.Message.Text != "first" || job.State != database.JobStateScraping {
+		t.Fatalf("Expected to claim the first job, got %v, %v", job, err)
+	}
+
+	// simulate a restart while the first job was in flight
+	resumed, err := db.ResumeJobs()
+	if err != nil || resumed != 1 {
+		t.Fatalf("Expected 1 resumed job, got %d, %v", resumed, err)
+	}
+	job, err = db.ClaimJob()
+	if err != nil || job == nil || job.Message.Text != "first" {
+		t.Fatalf("Expected to claim the first job again, got %v, %v", job, err)
+	}
+}
+
+func TestFindPostByURL(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if err := db.AddPost(&database.Post{
+		PostURL:    "https://x.com/i/status/1",
+		TargetChat: "@channel",
+		MessageIDs: []int{42},
+	}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	post, err := db.FindPostByURL("@channel", "https://x.com/i/status/1")
+	if err != nil || post == nil || post.MessageIDs[0] != 42 {
+		t.Errorf("Expected to find the post, got %v, %v", post, err)
+	}
+	post, err = db.FindPostByURL("@other", "https://x.com/i/status/1")
+	if err != nil || post != nil {
+		t.Errorf("Expected no post in another chat, got %v, %v", post, err)
+	}
+}
+
+func TestDelayedJobsAreNotClaimedEarly(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if err := db.EnqueueJobs([]utils.IncomingMessage{{Text: "only"}}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	job, _ := db.ClaimJob()
+	if err := db.RetryJob(job.ID, "502", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if job, err := db.ClaimJob(); err != nil || job != nil {
+		t.Errorf("Expected no claimable job, got %v, %v", job, err)
+	}
+}
+
+func TestJobTurnFollowsLineOrder(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if err := db.EnqueueJobs([]utils.IncomingMessage{{MessageID: 1, Text: "first"}, {MessageID: 1, Text: "second"}}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	first, _ := db.ClaimJob()
+	second, _ := db.ClaimJob()
+
+	if turn, err := db.IsJobTurn(second); err != nil || turn {
+		t.Errorf("Expected the second line to wait, got %v, %v", turn, err)
+	}
+	if err := db.SetJobState(first.ID, database.JobStateFailed, "404"); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if turn, err := db.IsJobTurn(second); err != nil || !turn {
+		t.Errorf("Expected the second line to go once the first is finished, got %v, %v", turn, err)
+	}
+}
+
+func TestDeferredJobsKeepTheirAttemptsAndFirstDeadline(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if err := db.EnqueueJobs([]utils.IncomingMessage{{Text: "only"}}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	job, _ := db.ClaimJob()
+	deadline := time.Now().Add(time.Minute)
+	if err := db.DeferJob(job.ID, time.Now().Add(time.Hour), deadline); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if job, err := db.ClaimJob(); err != nil || job != nil {
+		t.Errorf("Expected no claimable job, got %v, %v", job, err)
+	}
+
+	if err := db.DeferJob(job.ID, time.Time{}, deadline.Add(time.Hour)); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	job, err := db.ClaimJob()
+	if err != nil || job == nil {
+		t.Fatalf("Expected to claim the job again, got %v, %v", job, err)
+	}
+	if job.Attempts != 0 || !job.TurnDeadline.Equal(deadline) {
+		t.Errorf("Expected no attempt and the first deadline, got %d, %v", job.Attempts, job.TurnDeadline)
+	}
+}
+
+func TestOnlyScheduledJobsCanBeMoved(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if err := db.EnqueueJobs([]utils.IncomingMessage{{Text: "first"}, {Text: "second"}}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	scheduled, _ := db.ClaimJob()
+	running, _ := db.ClaimJob()
+	if err := db.ScheduleJob(scheduled.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	at := time.Now().Add(2 * time.Hour)
+	if err := db.RescheduleJob(running.ID, at); err == nil {
+		t.Error("Expected a job being processed not to be moved")
+	}
+	if err := db.RescheduleJob(scheduled.ID, at); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if job, err := db.GetJob(scheduled.ID); err != nil || job.State != database.JobStateScheduled || !job.ScheduledAt.Equal(at) {
+		t.Errorf("Expected the job to be moved, got %+v, %v", job, err)
+	}
+	if job, err := db.GetJob(running.ID); err != nil || job.State != database.JobStateScraping {
+		t.Errorf("Expected the running job to be left alone, got %+v, %v", job, err)
+	}
+}
+
+func TestPostingQueueReleasesOneJobPerSlot(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if err := db.EnqueueJobs([]utils.IncomingMessage{{Text: "first"}, {Text: "second"}}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	for range 2 {
+		job, _ := db.ClaimJob()
+		if err := db.HoldJob(job.ID, "@channel"); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+	}
+	second := uint64(2)
+	if err := db.BumpJob(second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	slot := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	job, err := db.ReleaseSlot("@channel", slot)
+	if err != nil || job == nil || job.Message.Text != "second" {
+		t.Fatalf("Expected the bumped job to be released, got %v, %v", job, err)
+	}
+	if job, err := db.ReleaseSlot("@channel", slot); err != nil || job != nil {
+		t.Errorf("Expected nothing more in the same slot, got %v, %v", job, err)
+	}
+	job, err = db.ReleaseSlot("@channel", slot.Add(90*time.Minute))
+	if err != nil || job == nil || job.Message.Text != "first" {
+		t.Errorf("Expected the first job in the next slot, got %v, %v", job, err)
+	}
+}
+
+func TestDraftsKeepTheEditsOfThePreview(t *testing.T) {
+	db := openTestDatabase(t)
+
+	draft := &database.Draft{
+		JobID: 7,
+		Media: []social.ScrapedMedia{
+			{MediaType: social.MediaTypePhoto, MediaUrl: "https://example.com/1.jpg"},
+			{MediaType: social.MediaTypePhoto, MediaUrl: "https://example.com/2.jpg"},
+		},
+		Dropped:         map[int]bool{0: true},
+		PreviewChat:     "42",
+		PromptMessageID: 100,
+	}
+	if err := db.SaveDraft(draft); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	found, err := db.FindDraftByPrompt("42", 100)
+	if err != nil || found == nil || found.JobID != 7 {
+		t.Fatalf("Expected to find the draft by its prompt, got %v, %v", found, err)
+	}
+	kept := found.KeptMedia()
+	if len(kept) != 1 || kept[0].MediaUrl != "https://example.com/2.jpg" {
+		t.Errorf("Expected only the second media to be kept, got %v", kept)
+	}
+
+	if err := db.DeleteDraft(7); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if draft, err := db.GetDraft(7); err != nil || draft != nil {
+		t.Errorf("Expected the draft to be deleted, got %v, %v", draft, err)
+	}
+}
+
+func TestLocalArtistsOwnTheirAliases(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if err := db.SaveLocalArtist(&database.Artist{Username: "foo", DisplayName: "Foo", Aliases: []string{"fa:Foo", "x:foo_art"}}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err := db.SaveLocalArtist(&database.Artist{Username: "bar", DisplayName: "Bar", Aliases: []string{"x:FOO_ART"}}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if artist, err := db.FindLocalArtist("fa", "foo"); err != nil || artist == nil || artist.AuthorInfo() != "@foo Foo" || len(artist.Aliases) != 1 {
+		t.Errorf("Expected fa:foo to stay with foo alone, got %+v, %v", artist, err)
+	}
+	if artist, err := db.FindLocalArtist("x", "foo_art"); err != nil || artist == nil || artist.Username != "bar" {
+		t.Errorf("Expected x:foo_art to move to bar, got %+v, %v", artist, err)
+	}
+
+	if deleted, err := db.DeleteLocalArtist("FOO"); err != nil || !deleted {
+		t.Fatalf("Expected foo to be deleted, got %v, %v", deleted, err)
+	}
+	if artist, err := db.FindLocalArtist("fa", "foo"); err != nil || artist != nil {
+		t.Errorf("Expected no artist for fa:foo, got %+v, %v", artist, err)
+	}
+}
+
+func TestLinkStatusesReplaceTheirLine(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if err := db.EnqueueJobs([]utils.IncomingMessage{{MessageID: 1, Text: "first"}, {MessageID: 1, Text: "second"}}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	first, _ := db.ClaimJob()
+
+	if _, err := db.SetLinkStatus(first, "@channel", "Queued"); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err := db.SetStatusReply(first.Message, 42); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	status, err := db.SetLinkStatus(first, "@channel", "Posted")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if status.ReplyID != 42 || status.Links != 2 || len(status.Lines) != 1 {
+		t.Fatalf("Expected one line of two links in reply 42, got %+v", status)
+	}
+	for _, line := range status.Lines {
+		if line.Text != "Posted" || line.Seq != first.Seq {
+			t.Errorf("Expected the line to be replaced, got %+v", line)
+		}
+	}
+}
+
+func TestUnauthorizedAttemptsAreListedNewestFirst(t *testing.T) {
+	db := openTestDatabase(t)
+
+	for _, text := range []string{"first", "second", "third"} {
+		if err := db.LogUnauthorizedAttempt(&database.UnauthorizedAttempt{UserID: 1, Text: text, At: time.Now()}); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+	}
+	attempts, err := db.ListUnauthorizedAttempts(2)
+	if err != nil || len(attempts) != 2 || attempts[0].Text != "third" || attempts[1].Text != "second" {
+		t.Fatalf("Expected the two latest attempts, got %v, %v", attempts, err)
+	}
+}
+
+func TestOnlyOneOfTwoQueuedJobsOfAPostIsPublished(t *testing.T) {
+	db := openTestDatabase(t)
+	check := database.DuplicateCheck{RefuseSimilar: true, Threshold: 10}
+	newPost := func(jobID uint64, hash uint64) *database.Post {
+		return &database.Post{PostURL: "https://x.com/foo/status/1", TargetChat: "@channel", JobID: jobID, ImageHashes: []uint64{hash}}
+	}
+
+	first := newPost(1, 0)
+	if reservation, err := db.ReservePost(first, check); err != nil || !reservation.Saved {
+		t.Fatalf("Expected the first job to reserve the post, got %+v, %v", reservation, err)
+	}
+	reservation, err := db.ReservePost(newPost(2, 0), check)
+	if err != nil || reservation.Saved || reservation.SameURL == nil || reservation.SameURL.JobID != 1 {
+		t.Fatalf("Expected the second job to be refused, got %+v, %v", reservation, err)
+	}
+
+	// a retry of the first job takes its reservation over
+	retry := newPost(1, 0)
+	if reservation, err := db.ReservePost(retry, check); err != nil || !reservation.Saved || retry.ID != first.ID {
+		t.Fatalf("Expected the retry to take the reservation over, got %+v, %v", reservation, err)
+	}
+	if err := db.CompletePost(first.ID, []int{42}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	post, err := db.FindPostByURL("@channel", "https://x.com/foo/status/1")
+	if err != nil || post == nil || post.Pending || post.Link() != "https://t.me/channel/42" {
+		t.Fatalf("Expected the post to be completed, got %+v, %v", post, err)
+	}
+
+	// forcing it again only goes through the similar images
+	other := &database.Post{PostURL: "https://x.com/bar/status/2", TargetChat: "@channel", JobID: 3, ImageHashes: []uint64{1}}
+	if reservation, err := db.ReservePost(other, check); err != nil || reservation.Saved || reservation.Similar == nil {
+		t.Fatalf("Expected a similar image to be refused, got %+v, %v", reservation, err)
+	}
+	forced := newPost(4, 1<<63)
+	if reservation, err := db.ReservePost(forced, database.DuplicateCheck{Force: true, Threshold: 10}); err != nil || !reservation.Saved {
+		t.Fatalf("Expected the forced post to be reserved, got %+v, %v", reservation, err)
+	}
+
+	// a failed send gives the URL back to the previous post
+	if err := db.DeletePost(forced.ID); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if post, err := db.FindPostByURL("@channel", "https://x.com/foo/status/1"); err != nil || post == nil || post.ID != first.ID {
+		t.Errorf("Expected the URL to point to the first post again, got %+v, %v", post, err)
+	}
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"social-2-telego/social"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// A scraped post waiting to be published, kept from the scraping of its job to
+// the publishing so it can be previewed and edited in between, and so a retry
+// or a slot in the posting queue doesn't scrape it again
+type Draft struct {
+	JobID uint64 `json:"job_id"`
+	// The URL as it was given and its canonical form
+	PostURL      string `json:"post_url"`
+	CanonicalURL string `json:"canonical_url"`
+	Source       string `json:"source"`
+	Title        string `json:"title,omitempty"`
+	Rating       string `json:"rating,omitempty"`
+	// The quoted text of the post, escaped for MarkdownV2
+	Content    string `json:"content"`
+	AuthorInfo string `json:"author_info"`
+	Hashtags   string `json:"hashtags"`
+	// The media as scraped, they're processed again on each send
+	Media      []social.ScrapedMedia `json:"media"`
+	TargetChat string                `json:"target_chat"`
+	// The name of the target the chat belongs to, empty when the post is
+	// echoed back to its sender
+	TargetName string `json:"target_name,omitempty"`
+	// When the post is published, zero to publish it as soon as possible
+	ScheduleAt time.Time `json:"schedule_at"`
+	// Publish right away even if the target has a posting queue
+	SkipQueue bool `json:"skip_queue"`
+	// Post even if it's a duplicate
+	Force bool `json:"force,omitempty"`
+	// The author ArtistDB doesn't know, added to it once the post is
+	// published. Nil when the author is known or credited by the input
+	NewArtist *ArtistStub `json:"new_artist,omitempty"`
+
+	// Edits made in the preview
+	Spoiler bool         `json:"spoiler"`
+	Dropped map[int]bool `json:"dropped,omitempty"`
+
+	// The preview sent to the requester, and the message asking for a new
+	// caption if one is pending
+	RequesterID       int    `json:"requester_id"`
+	PreviewChat       string `json:"preview_chat"`
+	PreviewMessageIDs []int  `json:"preview_message_ids"`
+	ControlMessageID  int    `json:"control_message_id"`
+	PromptMessageID   int    `json:"prompt_message_id"`
+
+	// The same for the review by moderators, the prompt asks for the reason
+	// of a rejection
+	ReviewChat             string `json:"review_chat,omitempty"`
+	ReviewMessageIDs       []int  `json:"review_message_ids,omitempty"`
+	ReviewControlMessageID int    `json:"review_control_message_id,omitempty"`
+	ReviewPromptMessageID  int    `json:"review_prompt_message_id,omitempty"`
+}
+
+// What ArtistDB is told about an artist it doesn't know, as scraped
+type ArtistStub struct {
+	Source      string `json:"source"`
+	Handle      string `json:"handle"`
+	ProfileURL  string `json:"profile_url"`
+	DisplayName string `json:"display_name"`
+}
+
+// The media which weren't dropped in the preview, in order
+func (d *Draft) KeptMedia() []social.ScrapedMedia {
+	kept := make([]social.ScrapedMedia, 0, len(d.Media))
+	for i, media := range d.Media {
+		if !d.Dropped[i] {
+			kept = append(kept, media)
+		}
+	}
+	return kept
+}
+
+// Whether a message is the caption prompt of the draft
+func (d *Draft) IsPrompt(chat string, messageID int) bool {
+	return d.PromptMessageID != 0 && d.PreviewChat == chat && d.PromptMessageID == messageID
+}
+
+// Whether a message is the rejection prompt of the draft
+func (d *Draft) IsReviewPrompt(chat string, messageID int) bool {
+	return d.ReviewPromptMessageID != 0 && d.ReviewChat == chat && d.ReviewPromptMessageID == messageID
+}
+
+// Save the draft of a job, replacing the previous one
+func (d *Database) SaveDraft(draft *Draft) error {
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		data, err := json.Marshal(draft)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(draftsBucket).Put(itob(draft.JobID), data)
+	}); err != nil {
+		return fmt.Errorf("Database.SaveDraft: %w", err)
+	}
+	return nil
+}
+
+// Get the draft of a job, nil if it has none
+func (d *Database) GetDraft(jobID uint64) (*Draft, error) {
+	var draft *Draft
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(draftsBucket).Get(itob(jobID))
+		if data == nil {
+			return nil
+		}
+		draft = &Draft{}
+		return json.Unmarshal(data, draft)
+	}); err != nil {
+		return nil, fmt.Errorf("Database.GetDraft: %w", err)
+	}
+	return draft, nil
+}
+
+// Find the draft whose caption or rejection prompt is the given message, nil
+// if none
+func (d *Database) FindDraftByPrompt(chat string, messageID int) (*Draft, error) {
+	var found *Draft
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(draftsBucket).ForEach(func(_, value []byte) error {
+			draft := &Draft{}
+			if err := json.Unmarshal(value, draft); err != nil {
+				return err
+			}
+			if draft.IsPrompt(chat, messageID) || draft.IsReviewPrompt(chat, messageID) {
+				found = draft
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("Database.FindDraftByPrompt: %w", err)
+	}
+	return found, nil
+}
+
+// Create a sibling job for each draft, so one post can be published to several
+// targets. The siblings share the message and its line of the job they come
+// from and are queued with their draft already made
+func (d *Database) ForkJob(job *Job, drafts []*Draft) error {
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		for _, draft := range drafts {
+			id, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			if err := putJob(bucket, &Job{
+				ID:        id,
+				State:     JobStateQueued,
+				Message:   job.Message,
+				CreatedAt: job.CreatedAt,
+				UpdatedAt: now,
+				Seq:       job.Seq,
+			}); err != nil {
+				return err
+			}
+
+			draft.JobID = id
+			data, err := json.Marshal(draft)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(draftsBucket).Put(itob(id), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.ForkJob: %w", err)
+	}
+
+	d.notifyJobs()
+	return nil
+}
+
+// Delete the draft of a job, if any
+func (d *Database) DeleteDraft(jobID uint64) error {
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(draftsBucket).Delete(itob(jobID))
+	}); err != nil {
+		return fmt.Errorf("Database.DeleteDraft: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,525 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"social-2-telego/utils"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type JobState string
+
+const (
+	JobStateQueued   JobState = "queued"
+	JobStateScraping JobState = "scraping"
+	JobStateSending  JobState = "sending"
+	JobStateDone     JobState = "done"
+	JobStateFailed   JobState = "failed"
+	// Ran out of retries, kept until replayed by hand
+	JobStateDead JobState = "dead"
+	// Waiting for its scheduled time, then queued again
+	JobStateScheduled JobState = "scheduled"
+	JobStateCancelled JobState = "cancelled"
+	// Scraped and waiting for a free slot of its target's posting queue
+	JobStateWaiting JobState = "waiting"
+	// Previewed to the requester, waiting to be published or cancelled
+	JobStatePreview JobState = "preview"
+	// Waiting for a moderator to approve or reject it
+	JobStateReview   JobState = "review"
+	JobStateRejected JobState = "rejected"
+)
+
+// Finished jobs are kept for a while for inspection, then pruned on startup
+const finishedJobRetention = 7 * 24 * time.Hour
+
+// One line of an incoming message waiting to be, or being, posted
+type Job struct {
+	ID        uint64                `json:"id"`
+	State     JobState              `json:"state"`
+	Message   utils.IncomingMessage `json:"message"`
+	Error     string                `json:"error,omitempty"`
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt time.Time             `json:"updated_at"`
+	// How many times the job failed with a retryable error, and when it may
+	// be tried again
+	Attempts  int       `json:"attempts"`
+	NotBefore time.Time `json:"not_before"`
+	// The position of the line in its message, lines of the same message are
+	// published in this order
+	Seq int `json:"seq"`
+	// When the job is published, zero if it's not scheduled
+	ScheduledAt time.Time `json:"scheduled_at"`
+	// The target whose posting queue the job went through and its position
+	// in it, lowest first. Target is empty if it was never held for a slot
+	Target string `json:"target,omitempty"`
+	Rank   int64  `json:"rank,omitempty"`
+	// Whether the requester chose to publish the preview
+	Approved bool `json:"approved,omitempty"`
+	// Whether a moderator approved the post
+	Reviewed bool `json:"reviewed,omitempty"`
+	// When the job stops waiting for the lines before it and is published
+	// out of order, zero until it first has to wait
+	TurnDeadline time.Time `json:"turn_deadline,omitempty"`
+}
+
+// Check whether a job is finished one way or another
+func (j *Job) isFinished() bool {
+	switch j.State {
+	case JobStateDone, JobStateFailed, JobStateDead, JobStateCancelled, JobStateRejected:
+		return true
+	default:
+		return false
+	}
+}
+
+// Check whether two jobs come from the same incoming message
+func (j *Job) sameMessage(other *Job) bool {
+	return j.Message.Chat.ID == other.Message.Chat.ID && j.Message.MessageID == other.Message.MessageID
+}
+
+// Read a job from its bucket value
+func decodeJob(data []byte) (*Job, error) {
+	job := &Job{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Write a job into its bucket
+func putJob(bucket *bolt.Bucket, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(itob(job.ID), data)
+}
+
+// Queue one job per line of a message, in order. All or none of them are saved
+func (d *Database) EnqueueJobs(messages []utils.IncomingMessage) error {
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		for seq, message := range messages {
+			id, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			if err := putJob(bucket, &Job{
+				ID:        id,
+				State:     JobStateQueued,
+				Message:   message,
+				CreatedAt: now,
+				UpdatedAt: now,
+				Seq:       seq,
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.EnqueueJobs: %w", err)
+	}
+
+	d.notifyJobs()
+	return nil
+}
+
+// Wake up one worker waiting for jobs, if any
+func (d *Database) notifyJobs() {
+	select {
+	case d.jobAdded <- struct{}{}:
+	default:
+	}
+}
+
+// A channel receiving a value whenever new jobs are queued
+func (d *Database) JobAdded() <-chan struct{} {
+	return d.jobAdded
+}
+
+// Take the oldest queued job and mark it as scraping. Returns nil when there's
+// nothing to do
+func (d *Database) ClaimJob() (*Job, error) {
+	var claimed *Job
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		cursor := bucket.Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			job, err := decodeJob(value)
+			if err != nil {
+				return err
+			}
+			if job.State != JobStateQueued || time.Now().Before(job.NotBefore) {
+				continue
+			}
+			job.State = JobStateScraping
+			job.UpdatedAt = time.Now()
+			claimed = job
+			return putJob(bucket, job)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("Database.ClaimJob: %w", err)
+	}
+
+	// there might be more, let another worker check
+	if claimed != nil {
+		d.notifyJobs()
+	}
+	return claimed, nil
+}
+
+// Move a job to another state, `errMsg` is only kept for failed jobs
+func (d *Database) SetJobState(id uint64, state JobState, errMsg string) error {
+	if err := d.updateJob(id, func(job *Job) error {
+		job.State = state
+		job.Error = errMsg
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.SetJobState: %w", err)
+	}
+	return nil
+}
+
+// Put the jobs interrupted by a crash or a restart back in the queue and
+// prune old finished jobs, returns the number of resumed jobs. A job that was
+// interrupted while sending might end up partially posted twice, the post
+// history catches it if it was fully sent
+func (d *Database) ResumeJobs() (int, error) {
+	resumed := 0
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		pruned := make([][]byte, 0)
+		cursor := bucket.Cursor()
+		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
+			job, err := decodeJob(value)
+			if err != nil {
+				return err
+			}
+			switch job.State {
+			case JobStateScraping, JobStateSending:
+				job.State = JobStateQueued
+				job.UpdatedAt = time.Now()
+				if err := putJob(bucket, job); err != nil {
+					return err
+				}
+				resumed++
+			case JobStateDone, JobStateFailed, JobStateCancelled, JobStateRejected:
+				if time.Since(job.UpdatedAt) > finishedJobRetention {
+					pruned = append(pruned, key)
+				}
+			}
+		}
+
+		// deleting while iterating makes the cursor skip keys
+		for _, key := range pruned {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+			if err := tx.Bucket(draftsBucket).Delete(key); err != nil {
+				return err
+			}
+		}
+		return pruneStatuses(tx)
+	}); err != nil {
+		return 0, fmt.Errorf("Database.ResumeJobs: %w", err)
+	}
+	return resumed, nil
+}
+
+// Delete the statuses of the messages which don't have jobs anymore
+func pruneStatuses(tx *bolt.Tx) error {
+	kept := make(map[string]bool)
+	if err := tx.Bucket(jobsBucket).ForEach(func(_, data []byte) error {
+		job, err := decodeJob(data)
+		if err != nil {
+			return err
+		}
+		kept[string(statusKey(job.Message))] = true
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	pruned := make([][]byte, 0)
+	if err := tx.Bucket(statusesBucket).ForEach(func(key, _ []byte) error {
+		if !kept[string(key)] {
+			pruned = append(pruned, append([]byte{}, key...))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, key := range pruned {
+		if err := tx.Bucket(statusesBucket).Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update a job in a read-write transaction, failing if it doesn't exist
+func (d *Database) updateJob(id uint64, update func(job *Job) error) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		data := bucket.Get(itob(id))
+		if data == nil {
+			return fmt.Errorf("job %d not found", id)
+		}
+		job, err := decodeJob(data)
+		if err != nil {
+			return err
+		}
+		if err := update(job); err != nil {
+			return err
+		}
+		job.UpdatedAt = time.Now()
+		return putJob(bucket, job)
+	})
+}
+
+// Get a job by its ID, nil if it doesn't exist
+func (d *Database) GetJob(id uint64) (*Job, error) {
+	var job *Job
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get(itob(id))
+		if data == nil {
+			return nil
+		}
+		var err error
+		job, err = decodeJob(data)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("Database.GetJob: %w", err)
+	}
+	return job, nil
+}
+
+// Put a job back in the queue after a retryable error, it won't be claimed
+// again before `notBefore`
+func (d *Database) RetryJob(id uint64, errMsg string, notBefore time.Time) error {
+	if err := d.updateJob(id, func(job *Job) error {
+		job.State = JobStateQueued
+		job.Error = errMsg
+		job.Attempts++
+		job.NotBefore = notBefore
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.RetryJob: %w", err)
+	}
+	return nil
+}
+
+// Put a job back in the queue until `notBefore` without counting an attempt,
+// because the lines before it aren't published yet. The deadline of its first
+// wait is kept
+func (d *Database) DeferJob(id uint64, notBefore time.Time, deadline time.Time) error {
+	if err := d.updateJob(id, func(job *Job) error {
+		job.State = JobStateQueued
+		job.NotBefore = notBefore
+		if job.TurnDeadline.IsZero() {
+			job.TurnDeadline = deadline
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.DeferJob: %w", err)
+	}
+	return nil
+}
+
+// Put a dead or failed job back in the queue with a fresh retry budget
+func (d *Database) ReplayJob(id uint64) error {
+	if err := d.updateJob(id, func(job *Job) error {
+		if job.State != JobStateDead && job.State != JobStateFailed {
+			return fmt.Errorf("job %d is %s, only dead or failed jobs can be replayed", id, job.State)
+		}
+		job.State = JobStateQueued
+		job.Error = ""
+		job.Attempts = 0
+		job.NotBefore = time.Time{}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.ReplayJob: %w", err)
+	}
+
+	d.notifyJobs()
+	return nil
+}
+
+// List the jobs in a state, oldest first
+func (d *Database) ListJobs(state JobState) ([]*Job, error) {
+	jobs := make([]*Job, 0)
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, value []byte) error {
+			job, err := decodeJob(value)
+			if err != nil {
+				return err
+			}
+			if job.State == state {
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("Database.ListJobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// Check whether every line before this one in the same message is finished,
+// meaning this job can be published without breaking the order
+func (d *Database) IsJobTurn(job *Job) (bool, error) {
+	turn := true
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(_, value []byte) error {
+			other, err := decodeJob(value)
+			if err != nil {
+				return err
+			}
+			// a line scheduled for later or waiting for a slot doesn't hold
+			// back the others
+			var held bool
+			switch other.State {
+			case JobStateScheduled, JobStateWaiting, JobStatePreview, JobStateReview:
+				held = true
+			}
+			if other.sameMessage(job) && other.Seq < job.Seq && !other.isFinished() && !held {
+				turn = false
+			}
+			return nil
+		})
+	}); err != nil {
+		return false, fmt.Errorf("Database.IsJobTurn: %w", err)
+	}
+	return turn, nil
+}
+
+// Hold a job being processed until `at`, it's queued again by ReleaseDueJobs
+func (d *Database) ScheduleJob(id uint64, at time.Time) error {
+	if err := d.updateJob(id, func(job *Job) error {
+		if job.State != JobStateScraping {
+			return fmt.Errorf("job %d is %s, it can't be scheduled", id, job.State)
+		}
+		job.State = JobStateScheduled
+		job.ScheduledAt = at
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.ScheduleJob: %w", err)
+	}
+	return nil
+}
+
+// Move a scheduled job to another time. Jobs being processed can't be moved,
+// the worker would otherwise run them a second time once they're due
+func (d *Database) RescheduleJob(id uint64, at time.Time) error {
+	if err := d.updateJob(id, func(job *Job) error {
+		if job.State != JobStateScheduled {
+			return fmt.Errorf("job %d is %s, only scheduled jobs can be moved", id, job.State)
+		}
+		job.ScheduledAt = at
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.RescheduleJob: %w", err)
+	}
+	return nil
+}
+
+// Cancel a job which didn't start yet
+func (d *Database) CancelJob(id uint64) error {
+	if err := d.updateJob(id, func(job *Job) error {
+		switch job.State {
+		case JobStateScheduled, JobStateQueued, JobStateWaiting, JobStatePreview, JobStateReview:
+		default:
+			return fmt.Errorf("job %d is %s, it can't be cancelled", id, job.State)
+		}
+		job.State = JobStateCancelled
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.CancelJob: %w", err)
+	}
+	return nil
+}
+
+// Queue the scheduled jobs whose time has come, returns how many were queued
+func (d *Database) ReleaseDueJobs(now time.Time) (int, error) {
+	released := 0
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		due := make([]*Job, 0)
+		if err := bucket.ForEach(func(_, value []byte) error {
+			job, err := decodeJob(value)
+			if err != nil {
+				return err
+			}
+			if job.State == JobStateScheduled && !job.ScheduledAt.After(now) {
+				due = append(due, job)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, job := range due {
+			job.State = JobStateQueued
+			job.UpdatedAt = now
+			if err := putJob(bucket, job); err != nil {
+				return err
+			}
+		}
+		released = len(due)
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("Database.ReleaseDueJobs: %w", err)
+	}
+
+	if released > 0 {
+		d.notifyJobs()
+	}
+	return released, nil
+}
+
+// Mark a previewed job as approved and queue it again to be published
+func (d *Database) ApproveJob(id uint64) error {
+	if err := d.updateJob(id, func(job *Job) error {
+		if job.State != JobStatePreview {
+			return fmt.Errorf("job %d is %s, only previewed jobs can be approved", id, job.State)
+		}
+		job.State = JobStateQueued
+		job.Approved = true
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.ApproveJob: %w", err)
+	}
+
+	d.notifyJobs()
+	return nil
+}
+
+// Record the decision of a moderator on a job under review. An approved job is
+// queued again to be published, a rejected one keeps the reason as its error
+func (d *Database) ReviewJob(id uint64, approved bool, reason string) error {
+	if err := d.updateJob(id, func(job *Job) error {
+		if job.State != JobStateReview {
+			return fmt.Errorf("job %d is %s, only jobs under review can be reviewed", id, job.State)
+		}
+		if !approved {
+			job.State = JobStateRejected
+			job.Error = reason
+			return nil
+		}
+		job.State = JobStateQueued
+		job.Reviewed = true
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.ReviewJob: %w", err)
+	}
+
+	if approved {
+		d.notifyJobs()
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"time"
+
+	"social-2-telego/utils"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// A post that was published to a target chat
+type Post struct {
+	ID uint64 `json:"id"`
+	// The canonical URL of the source post, the same post always gets the
+	// same URL no matter how it was linked
+	PostURL    string    `json:"post_url"`
+	Source     string    `json:"source"`
+	Author     string    `json:"author"`
+	TargetChat string    `json:"target_chat"`
+	MessageIDs []int     `json:"message_ids"`
+	PostedAt   time.Time `json:"posted_at"`
+	// The perceptual hashes of the images in the post
+	ImageHashes []uint64 `json:"image_hashes"`
+	// The job publishing the post, and whether it's still being sent
+	JobID   uint64 `json:"job_id,omitempty"`
+	Pending bool   `json:"pending,omitempty"`
+}
+
+// Get the link to the first message of a post, empty when the chat has no
+// public links or nothing was sent yet
+func (p *Post) Link() string {
+	if len(p.MessageIDs) == 0 {
+		return ""
+	}
+	return utils.MessageLink(p.TargetChat, p.MessageIDs[0])
+}
+
+// Save a newly published post, its ID is assigned here
+func (d *Database) AddPost(post *Post) error {
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		return putPost(tx, post)
+	}); err != nil {
+		return fmt.Errorf("Database.AddPost: %w", err)
+	}
+	return nil
+}
+
+// Save a post and index its URL, assigning its ID when it has none
+func putPost(tx *bolt.Tx, post *Post) error {
+	bucket := tx.Bucket(postsBucket)
+	if post.ID == 0 {
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		post.ID = id
+	}
+
+	data, err := json.Marshal(post)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put(itob(post.ID), data); err != nil {
+		return err
+	}
+
+	if post.PostURL == "" {
+		return nil
+	}
+	return tx.Bucket(postURLsBucket).Put(postURLKey(post.TargetChat, post.PostURL), itob(post.ID))
+}
+
+// How a post is checked against the history before it's sent
+type DuplicateCheck struct {
+	// Post it again even if it's a duplicate
+	Force bool
+	// Refuse it when an image is too similar to one already posted
+	RefuseSimilar bool
+	// How many bits two image hashes can differ by to be similar
+	Threshold int
+}
+
+// What reserving a post found in the history
+type Reservation struct {
+	// Whether the post was saved, it's not when it's a refused duplicate
+	Saved bool
+	// The post of the same URL, or with a similar image, already there
+	SameURL *Post
+	Similar *Post
+}
+
+// Reserve the place of a post in the history of its target before it's sent.
+// The duplicates are looked for in the same transaction, so two jobs of the
+// same post can't both go through. A reservation left by the same job, whose
+// send didn't finish, is taken over. The post is then completed with
+// CompletePost, or removed with DeletePost when nothing could be sent
+func (d *Database) ReservePost(post *Post, check DuplicateCheck) (*Reservation, error) {
+	reservation := &Reservation{}
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		sameURL, err := findPostByURL(tx, post.TargetChat, post.PostURL)
+		if err != nil {
+			return err
+		}
+		if sameURL != nil && sameURL.Pending && sameURL.JobID == post.JobID {
+			post.ID = sameURL.ID
+			post.Pending = true
+			reservation.Saved = true
+			return putPost(tx, post)
+		}
+		reservation.SameURL = sameURL
+		if sameURL != nil && !check.Force {
+			return nil
+		}
+
+		similar, err := findSimilarImage(tx, post.TargetChat, post.ImageHashes, check.Threshold)
+		if err != nil {
+			return err
+		}
+		reservation.Similar = similar
+		if similar != nil && check.RefuseSimilar {
+			return nil
+		}
+
+		post.Pending = true
+		reservation.Saved = true
+		return putPost(tx, post)
+	}); err != nil {
+		return nil, fmt.Errorf("Database.ReservePost: %w", err)
+	}
+	return reservation, nil
+}
+
+// Mark a reserved post as published with the messages it was sent as
+func (d *Database) CompletePost(id uint64, messageIDs []int) error {
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket(postsBucket).Get(itob(id))
+		if data == nil {
+			return fmt.Errorf("post %d not found", id)
+		}
+		post := &Post{}
+		if err := json.Unmarshal(data, post); err != nil {
+			return err
+		}
+		post.MessageIDs = messageIDs
+		post.PostedAt = time.Now()
+		post.Pending = false
+		return putPost(tx, post)
+	}); err != nil {
+		return fmt.Errorf("Database.CompletePost: %w", err)
+	}
+	return nil
+}
+
+// Remove a post from the history. Its URL points to the previous post of the
+// same URL again, if there's one
+func (d *Database) DeletePost(id uint64) error {
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(postsBucket)
+		data := bucket.Get(itob(id))
+		if data == nil {
+			return nil
+		}
+		post := &Post{}
+		if err := json.Unmarshal(data, post); err != nil {
+			return err
+		}
+		if err := bucket.Delete(itob(id)); err != nil {
+			return err
+		}
+		if post.PostURL == "" {
+			return nil
+		}
+
+		key := postURLKey(post.TargetChat, post.PostURL)
+		if indexed := tx.Bucket(postURLsBucket).Get(key); indexed == nil || btoi(indexed) != id {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for postKey, value := cursor.Last(); postKey != nil; postKey, value = cursor.Prev() {
+			var previous Post
+			if err := json.Unmarshal(value, &previous); err != nil {
+				return err
+			}
+			if previous.TargetChat == post.TargetChat && previous.PostURL == post.PostURL {
+				return tx.Bucket(postURLsBucket).Put(key, postKey)
+			}
+		}
+		return tx.Bucket(postURLsBucket).Delete(key)
+	}); err != nil {
+		return fmt.Errorf("Database.DeletePost: %w", err)
+	}
+	return nil
+}
+
+// The key of the URL index, a post is unique per target chat
+func postURLKey(targetChat string, postURL string) []byte {
+	return []byte(targetChat + "\x00" + postURL)
+}
+
+// Find the latest post of a canonical URL in the target chat. Returns nil when
+// it was never posted there
+func (d *Database) FindPostByURL(targetChat string, postURL string) (*Post, error) {
+	var found *Post
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = findPostByURL(tx, targetChat, postURL)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("Database.FindPostByURL: %w", err)
+	}
+	return found, nil
+}
+
+func findPostByURL(tx *bolt.Tx, targetChat string, postURL string) (*Post, error) {
+	id := tx.Bucket(postURLsBucket).Get(postURLKey(targetChat, postURL))
+	if id == nil {
+		return nil, nil
+	}
+	data := tx.Bucket(postsBucket).Get(id)
+	if data == nil {
+		return nil, nil
+	}
+	found := &Post{}
+	if err := json.Unmarshal(data, found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// Find the most recent post in the target chat having an image within
+// `maxDistance` bits of one of the hashes. Returns nil when there's none
+func (d *Database) FindSimilarImage(targetChat string, hashes []uint64, maxDistance int) (*Post, error) {
+	var found *Post
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = findSimilarImage(tx, targetChat, hashes, maxDistance)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("Database.FindSimilarImage: %w", err)
+	}
+	return found, nil
+}
+
+func findSimilarImage(tx *bolt.Tx, targetChat string, hashes []uint64, maxDistance int) (*Post, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+
+	cursor := tx.Bucket(postsBucket).Cursor()
+	for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+		var post Post
+		if err := json.Unmarshal(value, &post); err != nil {
+			return nil, err
+		}
+		if post.TargetChat != targetChat {
+			continue
+		}
+		for _, postHash := range post.ImageHashes {
+			for _, hash := range hashes {
+				if bits.OnesCount64(postHash^hash) <= maxDistance {
+					return &post, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Hold a scraped job in the posting queue of a target, at the end of it. It's
+// queued again by ReleaseSlot
+func (d *Database) HoldJob(id uint64, target string) error {
+	if err := d.updateJob(id, func(job *Job) error {
+		if job.State != JobStateScraping {
+			return fmt.Errorf("job %d is %s, it can't be held", id, job.State)
+		}
+		job.State = JobStateWaiting
+		job.Target = target
+		job.Rank = int64(job.ID)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("Database.HoldJob: %w", err)
+	}
+	return nil
+}
+
+// List the jobs waiting in the posting queues, in the order they'll be
+// published
+func (d *Database) ListWaitingJobs() ([]*Job, error) {
+	jobs, err := d.ListJobs(JobStateWaiting)
+	if err != nil {
+		return nil, fmt.Errorf("Database.ListWaitingJobs: %w", err)
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].Rank < jobs[j].Rank
+	})
+	return jobs, nil
+}
+
+// Move a waiting job to the front of its posting queue
+func (d *Database) BumpJob(id uint64) error {
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		data := bucket.Get(itob(id))
+		if data == nil {
+			return fmt.Errorf("job %d not found", id)
+		}
+		bumped, err := decodeJob(data)
+		if err != nil {
+			return err
+		}
+		if bumped.State != JobStateWaiting {
+			return fmt.Errorf("job %d is %s, only waiting jobs can be bumped", id, bumped.State)
+		}
+
+		first := bumped.Rank
+		if err := bucket.ForEach(func(_, value []byte) error {
+			job, err := decodeJob(value)
+			if err != nil {
+				return err
+			}
+			if job.State == JobStateWaiting && job.Target == bumped.Target {
+				first = min(first, job.Rank)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		bumped.Rank = first - 1
+		bumped.UpdatedAt = time.Now()
+		return putJob(bucket, bumped)
+	}); err != nil {
+		return fmt.Errorf("Database.BumpJob: %w", err)
+	}
+	return nil
+}
+
+// Cancel every job waiting in a posting queue, or in all of them when
+// `target` is empty. Returns how many were cancelled
+func (d *Database) ClearQueue(target string) (int, error) {
+	cleared := 0
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+		waiting := make([]*Job, 0)
+		if err := bucket.ForEach(func(_, value []byte) error {
+			job, err := decodeJob(value)
+			if err != nil {
+				return err
+			}
+			if job.State == JobStateWaiting && (target == "" || job.Target == target) {
+				waiting = append(waiting, job)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, job := range waiting {
+			job.State = JobStateCancelled
+			job.UpdatedAt = time.Now()
+			if err := putJob(bucket, job); err != nil {
+				return err
+			}
+		}
+		cleared = len(waiting)
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("Database.ClearQueue: %w", err)
+	}
+	return cleared, nil
+}
+
+// Get the last slot a job of the target was released in, zero if none
+func (d *Database) LastSlot(target string) (time.Time, error) {
+	var last time.Time
+	if err := d.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(slotsBucket).Get([]byte(target))
+		if data == nil {
+			return nil
+		}
+		return last.UnmarshalBinary(data)
+	}); err != nil {
+		return time.Time{}, fmt.Errorf("Database.LastSlot: %w", err)
+	}
+	return last, nil
+}
+
+// Queue the first waiting job of a target for publishing in `slot`, unless a
+// job was already released in that slot. Returns nil when nothing was released
+func (d *Database) ReleaseSlot(target string, slot time.Time) (*Job, error) {
+	var released *Job
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		slots := tx.Bucket(slotsBucket)
+		if data := slots.Get([]byte(target)); data != nil {
+			var last time.Time
+			if err := last.UnmarshalBinary(data); err != nil {
+				return err
+			}
+			if !slot.After(last) {
+				return nil
+			}
+		}
+
+		bucket := tx.Bucket(jobsBucket)
+		if err := bucket.ForEach(func(_, value []byte) error {
+			job, err := decodeJob(value)
+			if err != nil {
+				return err
+			}
+			if job.State == JobStateWaiting && job.Target == target && (released == nil || job.Rank < released.Rank) {
+				released = job
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if released == nil {
+			return nil
+		}
+
+		released.State = JobStateQueued
+		released.UpdatedAt = time.Now()
+		if err := putJob(bucket, released); err != nil {
+			return err
+		}
+		data, err := slot.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return slots.Put([]byte(target), data)
+	}); err != nil {
+		return nil, fmt.Errorf("Database.ReleaseSlot: %w", err)
+	}
+
+	if released != nil {
+		d.notifyJobs()
+	}
+	return released, nil
+}
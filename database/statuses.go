@@ -0,0 +1,99 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"social-2-telego/utils"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The status message kept up to date in reply to an incoming message, with
+// one line per link and target
+type MessageStatus struct {
+	// The reply holding the status, 0 until it's sent
+	ReplyID int `json:"reply_id"`
+	// Keyed by the position of the link and the target chat
+	Lines map[string]StatusLine `json:"lines"`
+	// How many links the message has
+	Links int `json:"links"`
+}
+
+// The status of one link of a message for one target
+type StatusLine struct {
+	Seq  int    `json:"seq"`
+	Text string `json:"text"`
+}
+
+// The key of the status of a message
+func statusKey(msg utils.IncomingMessage) []byte {
+	return []byte(fmt.Sprintf("%d:%d", msg.Chat.ID, msg.MessageID))
+}
+
+// Set the status of the link of a job for a target chat, empty when it's not
+// known yet, replacing the previous one. Returns the updated status
+func (d *Database) SetLinkStatus(job *Job, chat string, text string) (*MessageStatus, error) {
+	status := &MessageStatus{}
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(statusesBucket)
+		key := statusKey(job.Message)
+		if data := bucket.Get(key); data != nil {
+			if err := json.Unmarshal(data, status); err != nil {
+				return err
+			}
+		}
+		if status.Lines == nil {
+			status.Lines = make(map[string]StatusLine)
+		}
+		status.Lines[fmt.Sprintf("%d %s", job.Seq, chat)] = StatusLine{Seq: job.Seq, Text: text}
+
+		// the lines of a message are its jobs with distinct positions, forked
+		// jobs share the position of the line they come from
+		seqs := make(map[int]bool)
+		if err := tx.Bucket(jobsBucket).ForEach(func(_, data []byte) error {
+			other, err := decodeJob(data)
+			if err != nil {
+				return err
+			}
+			if other.sameMessage(job) {
+				seqs[other.Seq] = true
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		status.Links = len(seqs)
+
+		data, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, data)
+	}); err != nil {
+		return nil, fmt.Errorf("Database.SetLinkStatus: %w", err)
+	}
+	return status, nil
+}
+
+// Remember the reply holding the status of a message
+func (d *Database) SetStatusReply(msg utils.IncomingMessage, replyID int) error {
+	if err := d.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(statusesBucket)
+		status := &MessageStatus{}
+		if data := bucket.Get(statusKey(msg)); data != nil {
+			if err := json.Unmarshal(data, status); err != nil {
+				return err
+			}
+		}
+		status.ReplyID = replyID
+		data, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(statusKey(msg), data)
+	}); err != nil {
+		return fmt.Errorf("Database.SetStatusReply: %w", err)
+	}
+	return nil
+}
@@ -5,4 +5,8 @@ go 1.22.0
 require (
 	github.com/joho/godotenv v1.5.1
 	github.com/lmittmann/tint v1.0.4
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/image v0.23.0
 )
+
+require golang.org/x/sys v0.4.0 // indirect
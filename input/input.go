@@ -0,0 +1,99 @@
+package input
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The flags a line can have, "+force" posts again what was already posted and
+// "+now" skips the posting queue
+var knownFlags = map[string]bool{"force": true, "now": true}
+
+// What an input line asks for, e.g.
+//
+//	https://x.com/foo/status/123 @bar "Bar, Baz" #fox #wip >nsfw +force at 18:00
+//
+// Elements can come in any order after or before the link, separated by
+// spaces or commas
+type Line struct {
+	URL string
+	// The credits overwrite, without the "@". Empty to credit the scraped
+	// artist
+	Username string
+	// The words and quoted strings given around the username, in order
+	DisplayName string
+	// With their "#"
+	Hashtags []string
+	Flags    map[string]bool
+	// The names of the targets given with ">"
+	Targets []string
+	// "at ..." or "in ...", empty to publish right away
+	Schedule string
+}
+
+// Parse an input line, schedules are relative to `now`. The errors are *Error,
+// telling where the mistake is
+func Parse(line string, now time.Time) (*Line, error) {
+	tokens, err := Tokenize(line, now)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Line{Hashtags: make([]string, 0), Flags: make(map[string]bool), Targets: make([]string, 0)}
+	displayName := make([]string, 0)
+	firstText := 0
+	scheduleColumn := 0
+	for _, token := range tokens {
+		switch token.Kind {
+		case TokenURL:
+			if result.URL != "" {
+				return nil, &Error{Column: token.Column, Message: "only one link per line"}
+			}
+			result.URL = token.Value
+		case TokenAuthor:
+			if result.Username != "" {
+				return nil, &Error{Column: token.Column, Message: "only one @username per line"}
+			}
+			result.Username = token.Value
+		case TokenText:
+			if firstText == 0 {
+				firstText = token.Column
+			}
+			displayName = append(displayName, token.Value)
+		case TokenHashtag:
+			result.Hashtags = append(result.Hashtags, "#"+token.Value)
+		case TokenFlag:
+			if !knownFlags[token.Value] {
+				return nil, &Error{Column: token.Column, Message: fmt.Sprintf("unknown flag +%s", token.Value)}
+			}
+			result.Flags[token.Value] = true
+		case TokenTarget:
+			result.Targets = append(result.Targets, token.Value)
+		case TokenSchedule:
+			if scheduleColumn != 0 {
+				return nil, &Error{Column: token.Column, Message: fmt.Sprintf("already scheduled at column %d", scheduleColumn)}
+			}
+			scheduleColumn = token.Column
+			result.Schedule = token.Value
+		}
+	}
+
+	switch {
+	case result.URL == "":
+		return nil, &Error{Column: 1, Message: "no post link found"}
+	case firstText != 0 && result.Username == "":
+		return nil, &Error{Column: firstText, Message: "unexpected text, a display name goes with an @username"}
+	}
+	result.DisplayName = strings.Join(displayName, " ")
+	return result, nil
+}
+
+// The credits overwrite as "@username Display Name", the form drafts keep
+// them in. Empty to credit the scraped artist
+func (l *Line) AuthorInfo() string {
+	if l.Username == "" {
+		return ""
+	}
+	return strings.TrimSpace("@" + l.Username + " " + l.DisplayName)
+}
@@ -0,0 +1,118 @@
+package input_test
+
+import (
+	"errors"
+	"slices"
+	"social-2-telego/input"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+var now = time.Date(2026, 10, 19, 20, 0, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		line        string
+		username    string
+		displayName string
+		hashtags    []string
+		targets     []string
+		schedule    string
+	}{
+		{"https://x.com/foo/status/123", "", "", nil, nil, ""},
+		{"https://x.com/foo/status/123, @bar Bar, #baz", "bar", "Bar", []string{"#baz"}, nil, ""},
+		{"https://x.com/foo/status/123, #baz #qux, @bar", "bar", "", []string{"#baz", "#qux"}, nil, ""},
+		{`https://x.com/foo/status/123 @bar "Bar, Baz" #fox`, "bar", "Bar, Baz", []string{"#fox"}, nil, ""},
+		{"https://x.com/foo/status/123, Cat in Hat @cat, >nsfw >wip", "cat", "Cat in Hat", nil, []string{"nsfw", "wip"}, ""},
+		{`https://x.com/foo/status/123 "Foo" @bar "Baz Qux"`, "bar", "Foo Baz Qux", nil, nil, ""},
+		{"https://x.com/foo/status/123 at 2026-10-20 18:00, @bar", "bar", "", nil, nil, "at 2026-10-20 18:00"},
+		{"https://x.com/foo/status/123 IN 1h30m", "", "", nil, nil, "in 1h30m"},
+	}
+	for _, c := range cases {
+		line, err := input.Parse(c.line, now)
+		if err != nil {
+			t.Errorf("%s: %v", c.line, err)
+			continue
+		}
+		if line.URL != "https://x.com/foo/status/123" || line.Username != c.username || line.DisplayName != c.displayName || line.Schedule != c.schedule ||
+			!slices.Equal(line.Hashtags, append([]string{}, c.hashtags...)) || !slices.Equal(line.Targets, append([]string{}, c.targets...)) {
+			t.Errorf("%s: unexpected %+v", c.line, line)
+		}
+	}
+
+	line, err := input.Parse("https://x.com/foo/status/123?a=1,2, +Force +now", now)
+	if err != nil || line.URL != "https://x.com/foo/status/123?a=1,2" || !line.Flags["force"] || !line.Flags["now"] {
+		t.Errorf("Unexpected %+v, %v", line, err)
+	}
+}
+
+func TestAuthorInfo(t *testing.T) {
+	cases := map[string]string{
+		"https://x.com/a":                      "",
+		"https://x.com/a @bar":                 "@bar",
+		`https://x.com/a "Foo" @bar "Baz Qux"`: "@bar Foo Baz Qux",
+	}
+	for text, expected := range cases {
+		line, err := input.Parse(text, now)
+		if err != nil || line.AuthorInfo() != expected {
+			t.Errorf("%s: expected %q, got %v, %v", text, expected, line, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := map[string]int{
+		"@bar Bar":                           1,
+		"https://x.com/foo/status/123, Bar":  31,
+		"https://x.com/foo/status/123 @a @b": 33,
+		"https://x.com/foo/status/123 \"Bar": 30,
+		"https://x.com/foo/status/123 # foo": 30,
+		"https://x.com/a https://x.com/b":    17,
+		"https://x.com/a in 1h at 18:00":     23,
+		"https://x.com/a in 3 weeks":         17,
+		"https://x.com/ä @bar \"":            22,
+		"https://x.com/a +force +forse":      24,
+		// a time which has passed isn't a schedule, so "at" is text
+		"https://x.com/a at 2026-10-19 18:00": 17,
+	}
+	for line, column := range cases {
+		_, err := input.Parse(line, now)
+		var inputErr *input.Error
+		if !errors.As(err, &inputErr) {
+			t.Errorf("%s: expected an input error, got %v", line, err)
+			continue
+		}
+		if inputErr.Column != column {
+			t.Errorf("%s: expected column %d, got %v", line, column, inputErr)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"https://x.com/foo/status/123, @bar Bar, #baz",
+		`https://www.furaffinity.net/view/1/ @bar "Bar, Baz" >nsfw +force at 2026-10-20 18:00`,
+		"https://x.com/foo/status/123 in 3h",
+		`"unclosed`,
+		"@ # + > at in",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		line, err := input.Parse(text, now)
+		if err != nil {
+			var inputErr *input.Error
+			if !errors.As(err, &inputErr) {
+				t.Fatalf("expected an input error, got %v", err)
+			}
+			if inputErr.Column < 1 || inputErr.Column > utf8.RuneCountInString(text)+1 {
+				t.Fatalf("column %d is out of the line", inputErr.Column)
+			}
+			return
+		}
+		if line.URL == "" {
+			t.Fatalf("parsed without a link")
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package input
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"social-2-telego/utils"
+)
+
+type TokenKind int
+
+const (
+	// A word or a quoted string, part of the display name of the artist
+	TokenText TokenKind = iota
+	// A link starting with http:// or https://
+	TokenURL
+	// "@username", the value is without the "@", same for the kinds below
+	TokenAuthor
+	// "#hashtag"
+	TokenHashtag
+	// "+flag", lowercased
+	TokenFlag
+	// ">target"
+	TokenTarget
+	// "at 18:00", "at 2026-10-20 18:00" or "in 3h", the value is all of it
+	TokenSchedule
+)
+
+// One element of an input line
+type Token struct {
+	Kind  TokenKind
+	Value string
+	// Where the token starts, counted in characters from 1
+	Column int
+}
+
+// A mistake in an input line
+type Error struct {
+	// Where the mistake is, counted in characters from 1
+	Column  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Column, e.Message)
+}
+
+// Whether a character ends a word. Commas separate elements like spaces do,
+// except inside links and quoted strings
+func isSeparator(r rune) bool {
+	return r == ',' || unicode.IsSpace(r)
+}
+
+// Split an input line into its tokens, schedules are checked against `now`
+func Tokenize(line string, now time.Time) ([]Token, error) {
+	runes := []rune(line)
+	tokens := make([]Token, 0)
+	for i := 0; i < len(runes); {
+		if isSeparator(runes[i]) {
+			i++
+			continue
+		}
+		start := i
+
+		// a quoted string is text, commas included
+		if runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, &Error{Column: start + 1, Message: "unclosed quote"}
+			}
+			tokens = append(tokens, Token{Kind: TokenText, Value: string(runes[i+1 : end]), Column: start + 1})
+			i = end + 1
+			continue
+		}
+
+		word, next := readWord(runes, i)
+		token := Token{Kind: TokenText, Value: word, Column: start + 1}
+		switch lower := strings.ToLower(word); {
+		case strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://"):
+			// links may contain commas, only trailing ones are separators
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) {
+				end++
+			}
+			token.Kind, token.Value = TokenURL, strings.TrimRight(string(runes[i:end]), ",")
+			next = end
+		case word[0] == '@', word[0] == '#', word[0] == '+', word[0] == '>':
+			if len(word) == 1 {
+				return nil, &Error{Column: start + 1, Message: fmt.Sprintf("%q must be followed by a name", word)}
+			}
+			token.Kind = map[byte]TokenKind{'@': TokenAuthor, '#': TokenHashtag, '+': TokenFlag, '>': TokenTarget}[word[0]]
+			token.Value = word[1:]
+			if token.Kind == TokenFlag {
+				token.Value = strings.ToLower(token.Value)
+			}
+		case lower == "at" || lower == "in":
+			// only a keyword when a valid time follows, so display names
+			// can still have these words
+			if schedule, end, ok := readSchedule(runes, next, lower, now); ok {
+				token.Kind, token.Value = TokenSchedule, schedule
+				next = end
+			}
+		}
+		tokens = append(tokens, token)
+		i = next
+	}
+	return tokens, nil
+}
+
+// Read the word starting at `start`, returning it and where it ends
+func readWord(runes []rune, start int) (string, int) {
+	end := start
+	for end < len(runes) && !isSeparator(runes[end]) && runes[end] != '"' {
+		end++
+	}
+	return string(runes[start:end]), end
+}
+
+// Read the value of an "at" or "in" keyword ending at `start`. "at" takes a
+// date and a time as two words when the first one is a date
+func readSchedule(runes []rune, start int, keyword string, now time.Time) (string, int, bool) {
+	skipSpaces := func(i int) int {
+		for i < len(runes) && unicode.IsSpace(runes[i]) {
+			i++
+		}
+		return i
+	}
+
+	valueStart := skipSpaces(start)
+	if valueStart == start || valueStart == len(runes) {
+		return "", 0, false
+	}
+	value, end := readWord(runes, valueStart)
+	if value == "" {
+		return "", 0, false
+	}
+	if keyword == "at" {
+		if _, err := time.Parse(time.DateOnly, value); err == nil {
+			if clockStart := skipSpaces(end); clockStart > end {
+				if clock, clockEnd := readWord(runes, clockStart); clock != "" {
+					if _, err := utils.ParseSchedule(keyword+" "+value+" "+clock, now); err == nil {
+						return keyword + " " + value + " " + clock, clockEnd, true
+					}
+				}
+			}
+		}
+	}
+
+	schedule := keyword + " " + value
+	if _, err := utils.ParseSchedule(schedule, now); err != nil {
+		return "", 0, false
+	}
+	return schedule, end, true
+}
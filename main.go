@@ -1,10 +1,13 @@
 package main
 
 import (
+	"log"
 	"log/slog"
 	"os"
 	"time"
+	_ "time/tzdata"
 
+	"social-2-telego/database"
 	"social-2-telego/message_listener"
 	"social-2-telego/telegram"
 	"social-2-telego/utils"
@@ -40,16 +43,39 @@ func init() {
 
 func main() {
 	// This one contains all the environment variables
-	// and a message channel to send messages to
 	appState := utils.NewAppState()
 
-	// This one listens to a channel and responds when there's a message, it's
+	// This one remembers what was posted and queues the incoming messages,
+	// so nothing is lost on restarts
+	db, err := database.Open(appState.GetDatabasePath())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	// This one takes jobs from the queue and responds to them, it's
 	// where all the magic happens. When something goes wrong, it's likely to be
 	// happening here
-	go telegram.Responder(appState)
+	client := telegram.NewClient(appState)
+	go telegram.Responder(appState, db, client)
+
+	// This one queues the scheduled posts and the posting queues when their
+	// time comes
+	go telegram.Scheduler(appState, db)
 
 	// This one listens to updates from Telegram (webhook or long-polling) and
-	// sends them to the message channel. This should not be breaking unless
-	// Telegram changes their API
-	message_listener.InitMessageListener(appState)
+	// queues them in the database, or runs them right away if they're
+	// commands or preview buttons. Unauthorized users are turned away here.
+	// This should not be breaking unless Telegram changes their API
+	commands := telegram.NewCommands(appState, db, client)
+	previews := telegram.NewPreviews(appState, db, client)
+	gate := telegram.NewGate(appState, db, client)
+	message_listener.InitMessageListener(appState, db, message_listener.Handlers{
+		Command:      commands.Handle,
+		Reply:        previews.HandleReply,
+		Callback:     previews.HandleCallback,
+		Unauthorized: gate.Reject,
+
+		UnauthorizedCallback: gate.RejectCallback,
+	})
 }
@@ -0,0 +1,312 @@
+package media_processor
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"social-2-telego/social"
+)
+
+const (
+	// Frames are shown for 100ms when the source doesn't say otherwise
+	defaultFrameDelay = 100
+	// Larger canvases are refused, a corrupted header could otherwise
+	// allocate gigabytes
+	maxCanvasSide = 16384
+	// How much of a PNG or WebP is fetched to tell whether it's animated,
+	// enough to get past the color profile PNGs can have before their frames
+	sniffSize = 64 * 1024
+)
+
+// One still image of an animation and how long it's shown, in milliseconds
+type frame struct {
+	path  string
+	delay int
+}
+
+// The delay of a frame in milliseconds. A delay of 0 asks for "as fast as
+// possible", which browsers show at the default pace
+func frameDelay(delay int) int {
+	if delay <= 0 {
+		return defaultFrameDelay
+	}
+	return delay
+}
+
+// Check that a frame is drawn inside its canvas
+func checkFrameBounds(rect image.Rectangle, canvas image.Rectangle) error {
+	if rect.Empty() || !rect.In(canvas) {
+		return fmt.Errorf("frame %v is out of the %v canvas", rect, canvas)
+	}
+	return nil
+}
+
+// Check that a canvas has a size worth decoding
+func checkCanvasSize(width int, height int) error {
+	if width <= 0 || height <= 0 || width > maxCanvasSide || height > maxCanvasSide {
+		return fmt.Errorf("canvas of %dx%d is not supported", width, height)
+	}
+	return nil
+}
+
+// Encode a sequence of frames into an H.264 MP4 keeping their timings
+func (p *Processor) encodeFrames(frames []frame, output string) error {
+	// the concat demuxer ignores the duration of the last entry unless the
+	// file is repeated once more
+	list := strings.Builder{}
+	list.WriteString("ffconcat version 1.0\n")
+	for _, f := range frames {
+		fmt.Fprintf(&list, "file '%s'\nduration %.3f\n", f.path, float64(f.delay)/1000)
+	}
+	fmt.Fprintf(&list, "file '%s'\n", frames[len(frames)-1].path)
+
+	listPath := filepath.Join(filepath.Dir(output), "frames.ffconcat")
+	if err := os.WriteFile(listPath, []byte(list.String()), 0o644); err != nil {
+		return fmt.Errorf("encodeFrames: %w", err)
+	}
+
+	if _, err := runCommand(p.appState.GetFfmpegPath(),
+		"-y", "-v", "error",
+		"-f", "concat", "-safe", "0",
+		"-i", listPath,
+		"-fps_mode", "vfr",
+		// H.264 with yuv420p needs even dimensions
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2,format=yuv420p",
+		"-c:v", "libx264", "-crf", "20", "-preset", "veryfast",
+		"-movflags", "+faststart",
+		"-an",
+		output,
+	); err != nil {
+		return fmt.Errorf("encodeFrames: %w", err)
+	}
+	return nil
+}
+
+// Extract the frames of an ugoira ZIP, using the frame list of the source for
+// the order and the delays when there is one
+func extractUgoiraFrames(zipPath string, ugoiraFrames []social.UgoiraFrame, dir string) ([]frame, error) {
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, fmt.Errorf("extractUgoiraFrames: %w", err)
+	}
+	defer archive.Close()
+
+	extracted := make(map[string]string)
+	names := make([]string, 0)
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		// never trust the paths inside an archive
+		name := filepath.Base(file.Name)
+		path := filepath.Join(dir, name)
+		if err := extractZipFile(file, path); err != nil {
+			return nil, fmt.Errorf("extractUgoiraFrames: %w", err)
+		}
+		extracted[name] = path
+		names = append(names, name)
+	}
+
+	frames := make([]frame, 0)
+	if len(ugoiraFrames) == 0 {
+		for _, name := range names {
+			frames = append(frames, frame{path: extracted[name], delay: defaultFrameDelay})
+		}
+	}
+	for _, ugoiraFrame := range ugoiraFrames {
+		path, ok := extracted[filepath.Base(ugoiraFrame.File)]
+		if !ok {
+			return nil, fmt.Errorf("extractUgoiraFrames: frame %s is not in the archive", ugoiraFrame.File)
+		}
+		frames = append(frames, frame{path: path, delay: frameDelay(ugoiraFrame.Delay)})
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("extractUgoiraFrames: no frame found")
+	}
+	return frames, nil
+}
+
+// Write one file of a ZIP archive to the disk
+func extractZipFile(file *zip.File, path string) error {
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	output, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	written, err := io.Copy(output, io.LimitReader(reader, maxDownloadSize+1))
+	if err != nil {
+		return err
+	}
+	if written > maxDownloadSize {
+		return fmt.Errorf("%s is larger than %dMB", file.Name, maxDownloadSize/1024/1024)
+	}
+	return nil
+}
+
+// Convert an ugoira into a looping MP4 animation
+func (p *Processor) processUgoira(item social.ScrapedMedia, dir string) (social.ScrapedMedia, error) {
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return item, fmt.Errorf("processUgoira: %w", err)
+	}
+
+	zipPath, err := download(item.MediaUrl, dir)
+	if err != nil {
+		return item, fmt.Errorf("processUgoira: %w", err)
+	}
+	framesDir := filepath.Join(dir, "frames")
+	if err := os.Mkdir(framesDir, 0o755); err != nil {
+		return item, fmt.Errorf("processUgoira: %w", err)
+	}
+	frames, err := extractUgoiraFrames(zipPath, item.Frames, framesDir)
+	if err != nil {
+		return item, fmt.Errorf("processUgoira: %w", err)
+	}
+
+	animation, err := p.framesToAnimation(item, frames, dir)
+	if err != nil {
+		return item, err
+	}
+	animation.Frames = nil
+	return animation, nil
+}
+
+// Tell from the first bytes of an image whether it's an animated PNG or
+// WebP. `known` is false when the bytes end before it can be told
+func sniffAnimation(head []byte) (animated bool, known bool) {
+	switch {
+	case bytes.HasPrefix(head, pngSignature):
+		return sniffAPNG(head)
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return sniffAnimatedWebP(head)
+	default:
+		return false, true
+	}
+}
+
+// Whether a photo can be an animated PNG or WebP, judging by its URL
+func mightBeAnimated(item social.ScrapedMedia) bool {
+	switch strings.ToLower(filepath.Ext(strings.Split(item.MediaUrl, "?")[0])) {
+	case ".png", ".apng", ".webp":
+		return true
+	default:
+		return false
+	}
+}
+
+// Convert an animated PNG or WebP into a looping MP4 animation without hashing
+// it. Still images are returned untouched, most of them are told apart by
+// their first bytes without downloading the whole file
+func (p *Processor) processAnimatedImage(item social.ScrapedMedia, dir string) (social.ScrapedMedia, error) {
+	if !mightBeAnimated(item) {
+		return item, nil
+	}
+	if head, err := downloadHead(item.MediaUrl, sniffSize); err != nil {
+		slog.Debug("can't sniff the image, downloading it", "url", item.MediaUrl, "err", err)
+	} else if animated, known := sniffAnimation(head); known && !animated {
+		return item, nil
+	}
+
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return item, fmt.Errorf("processAnimatedImage: %w", err)
+	}
+	path, err := download(item.MediaUrl, dir)
+	if err != nil {
+		return item, fmt.Errorf("processAnimatedImage: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return item, fmt.Errorf("processAnimatedImage: %w", err)
+	}
+	return p.convertAnimation(item, data, dir)
+}
+
+// Hash a downloaded image. Its size is read from its header first, so a small
+// file declaring a huge canvas isn't decoded
+func hashImage(data []byte) (uint64, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	if err := checkCanvasSize(config.Width, config.Height); err != nil {
+		return 0, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	return DHash(img), nil
+}
+
+// Download a photo and hash it. Animated PNGs and WebPs are converted into
+// a looping MP4 animation when ffmpeg is available, still images are returned
+// untouched and Telegram fetches them by URL
+func (p *Processor) processPhoto(item social.ScrapedMedia, dir string) (social.ScrapedMedia, uint64, bool, error) {
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return item, 0, false, fmt.Errorf("processPhoto: %w", err)
+	}
+	path, err := download(item.MediaUrl, dir)
+	if err != nil {
+		return item, 0, false, fmt.Errorf("processPhoto: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return item, 0, false, fmt.Errorf("processPhoto: %w", err)
+	}
+
+	hash, err := hashImage(data)
+	hashed := err == nil
+	if err != nil {
+		slog.Warn("failed to hash image", "url", item.MediaUrl, "err", err)
+	}
+
+	if !p.Enabled() {
+		return item, hash, hashed, nil
+	}
+	animation, err := p.convertAnimation(item, data, dir)
+	return animation, hash, hashed, err
+}
+
+// Convert a downloaded animated PNG or WebP into a looping MP4 animation, a
+// still image is returned untouched
+func (p *Processor) convertAnimation(item social.ScrapedMedia, data []byte, dir string) (social.ScrapedMedia, error) {
+	if animated, _ := sniffAnimation(data); !animated {
+		return item, nil
+	}
+	var frames []frame
+	var err error
+	if isAnimatedPNG(data) {
+		frames, err = extractAPNGFrames(data, dir)
+	} else {
+		frames, err = extractWebPFrames(data, dir)
+	}
+	if err != nil {
+		return item, fmt.Errorf("convertAnimation: %w", err)
+	}
+	return p.framesToAnimation(item, frames, dir)
+}
+
+// Encode the frames and turn the item into an animation pointing to the MP4
+func (p *Processor) framesToAnimation(item social.ScrapedMedia, frames []frame, dir string) (social.ScrapedMedia, error) {
+	output := filepath.Join(dir, "animation.mp4")
+	if err := p.encodeFrames(frames, output); err != nil {
+		return item, fmt.Errorf("framesToAnimation: %w", err)
+	}
+
+	item.MediaType = social.MediaTypeAnimation
+	item.FilePath = output
+	return p.describeVideo(item, dir)
+}
@@ -0,0 +1,332 @@
+package media_processor_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"social-2-telego/media_processor"
+	"social-2-telego/social"
+	"testing"
+)
+
+var (
+	red  = color.NRGBA{R: 255, A: 255}
+	blue = color.NRGBA{B: 255, A: 255}
+)
+
+// A PNG chunk with its length and CRC
+func pngChunk(chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// The IHDR and the joined IDAT data of an opaque PNG of a single color
+func encodePNG(t *testing.T, width int, height int, c color.NRGBA) ([]byte, []byte) {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{c.R, c.G, c.B, c.A})
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	var ihdr, idat []byte
+	data := buf.Bytes()[8:]
+	for len(data) >= 12 {
+		size := binary.BigEndian.Uint32(data)
+		switch string(data[4:8]) {
+		case "IHDR":
+			ihdr = data[8 : 8+size]
+		case "IDAT":
+			idat = append(idat, data[8:8+size]...)
+		}
+		data = data[12+size:]
+	}
+	return ihdr, idat
+}
+
+// The frame control of an APNG frame
+func fcTL(seq int, width int, height int, x int, y int, delayNum int, delayDen int) []byte {
+	data := make([]byte, 26)
+	for i, v := range []int{seq, width, height, x, y} {
+		binary.BigEndian.PutUint32(data[i*4:], uint32(v))
+	}
+	binary.BigEndian.PutUint16(data[20:], uint16(delayNum))
+	binary.BigEndian.PutUint16(data[22:], uint16(delayDen))
+	return pngChunk("fcTL", data)
+}
+
+// A 4x4 red APNG with a blue 2x2 second frame at (1, 1), shown for 250ms
+// and for the default delay
+func apngFixture(t *testing.T, secondX int) []byte {
+	ihdr, first := encodePNG(t, 4, 4, red)
+	_, second := encodePNG(t, 2, 2, blue)
+
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = append(data, pngChunk("IHDR", ihdr)...)
+	data = append(data, pngChunk("acTL", []byte{0, 0, 0, 2, 0, 0, 0, 0})...)
+	data = append(data, fcTL(0, 4, 4, 0, 0, 1, 4)...)
+	data = append(data, pngChunk("IDAT", first)...)
+	data = append(data, fcTL(1, 2, 2, secondX, 1, 0, 0)...)
+	data = append(data, pngChunk("fdAT", append([]byte{0, 0, 0, 2}, second...))...)
+	return append(data, pngChunk("IEND", nil)...)
+}
+
+// Writes the bits of a VP8L bitstream, least significant first
+type bitWriter struct {
+	data  []byte
+	nBits int
+}
+
+func (w *bitWriter) write(value int, n int) {
+	for i := 0; i < n; i++ {
+		if w.nBits%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte((value>>i)&1) << (w.nBits % 8)
+		w.nBits++
+	}
+}
+
+// A lossless WebP bitstream of a single color. Every prefix code has one
+// symbol, so the pixels take no bits
+func solidVP8L(width int, height int, c color.NRGBA) []byte {
+	w := &bitWriter{}
+	w.write(0x2f, 8)
+	w.write(width-1, 14)
+	w.write(height-1, 14)
+	w.write(0, 4) // alpha hint and version
+	w.write(0, 3) // no transform, color cache or meta prefix codes
+	for _, v := range []uint8{c.G, c.R, c.B, c.A} {
+		w.write(1, 1) // simple code
+		w.write(0, 1) // of one symbol
+		w.write(1, 1) // of 8 bits
+		w.write(int(v), 8)
+	}
+	w.write(1, 1) // the distance code, never used
+	w.write(0, 3)
+	return w.data
+}
+
+// A RIFF chunk, padded to an even size
+func riffChunk(fourCC string, data []byte) []byte {
+	chunk := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func uint24(v int) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+}
+
+// An animation frame of a WebP
+func anmf(x int, y int, width int, height int, duration int, c color.NRGBA) []byte {
+	header := slices.Concat(uint24(x/2), uint24(y/2), uint24(width-1), uint24(height-1), uint24(duration), []byte{0})
+	return riffChunk("ANMF", append(header, riffChunk("VP8L", solidVP8L(width, height, c))...))
+}
+
+// A 4x4 red WebP with a blue 2x2 second frame at (2, 2), shown for 250ms
+// and for the default delay
+func webpFixture(canvasSide int, secondX int) []byte {
+	vp8x := slices.Concat([]byte{0x02, 0, 0, 0}, uint24(canvasSide-1), uint24(canvasSide-1))
+	body := slices.Concat([]byte("WEBP"),
+		riffChunk("VP8X", vp8x),
+		riffChunk("ANIM", make([]byte, 6)),
+		anmf(0, 0, 4, 4, 250, red),
+		anmf(secondX, 2, 2, 2, 0, blue),
+	)
+	return slices.Concat([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body))), body)
+}
+
+// Check the color of a pixel of a frame file
+func checkPixel(t *testing.T, path string, x int, y int, want color.NRGBA) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if got := color.NRGBAModel.Convert(img.At(x, y)); got != want {
+		t.Errorf("%s: expected %v at (%d, %d), got %v", path, want, x, y, got)
+	}
+}
+
+func TestExtractAPNGFrames(t *testing.T) {
+	paths, delays, err := media_processor.ExtractAPNGFrames(apngFixture(t, 1), t.TempDir())
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !slices.Equal(delays, []int{250, 100}) {
+		t.Errorf("Expected delays of 250ms and 100ms, got %v", delays)
+	}
+	checkPixel(t, paths[1], 0, 0, red)
+	checkPixel(t, paths[1], 1, 1, blue)
+}
+
+func TestExtractAPNGFramesRejectsMalformedFiles(t *testing.T) {
+	valid := apngFixture(t, 1)
+	hugeCanvas := bytes.Clone(valid)
+	binary.BigEndian.PutUint32(hugeCanvas[16:], 1<<20)
+	cases := map[string][]byte{
+		"truncated chunk":      valid[:len(valid)-20],
+		"frame out of canvas":  apngFixture(t, 3),
+		"canvas too large":     hugeCanvas,
+		"fdAT before any fcTL": slices.Concat(valid[:33], pngChunk("fdAT", []byte{0, 0, 0, 0, 1})),
+		"no frame":             valid[:33],
+	}
+	for name, data := range cases {
+		if _, _, err := media_processor.ExtractAPNGFrames(data, t.TempDir()); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestExtractWebPFrames(t *testing.T) {
+	paths, delays, err := media_processor.ExtractWebPFrames(webpFixture(4, 2), t.TempDir())
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !slices.Equal(delays, []int{250, 100}) {
+		t.Errorf("Expected delays of 250ms and 100ms, got %v", delays)
+	}
+	checkPixel(t, paths[1], 0, 0, red)
+	checkPixel(t, paths[1], 3, 3, blue)
+}
+
+func TestExtractWebPFramesRejectsMalformedFiles(t *testing.T) {
+	valid := webpFixture(4, 2)
+	cases := map[string][]byte{
+		"truncated chunk":     valid[:len(valid)-3],
+		"frame out of canvas": webpFixture(4, 4),
+		"canvas too large":    webpFixture(1<<20, 2),
+		"not a WebP":          valid[:8],
+		"no frame":            valid[:12+8+10],
+	}
+	for name, data := range cases {
+		if _, _, err := media_processor.ExtractWebPFrames(data, t.TempDir()); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSniffAnimation(t *testing.T) {
+	_, still := encodePNG(t, 1, 1, red)
+	ihdr, _ := encodePNG(t, 1, 1, red)
+	stillPNG := slices.Concat([]byte("\x89PNG\r\n\x1a\n"), pngChunk("IHDR", ihdr), pngChunk("IDAT", still))
+	apng := apngFixture(t, 1)
+	webp := webpFixture(4, 2)
+	stillWebP := slices.Concat([]byte("RIFF\x00\x00\x00\x00WEBP"), riffChunk("VP8L", solidVP8L(1, 1, red)))
+
+	cases := []struct {
+		name            string
+		head            []byte
+		animated, known bool
+	}{
+		{"APNG", apng[:64], true, true},
+		{"still PNG", stillPNG, false, true},
+		{"PNG cut before acTL", apng[:20], false, false},
+		{"animated WebP", webp[:21], true, true},
+		{"still WebP", stillWebP, false, true},
+		{"WebP cut before its flags", webp[:18], false, false},
+		{"JPEG", []byte("\xff\xd8\xff\xe0"), false, true},
+	}
+	for _, c := range cases {
+		if animated, known := media_processor.SniffAnimation(c.head); animated != c.animated || known != c.known {
+			t.Errorf("%s: expected %v, %v, got %v, %v", c.name, c.animated, c.known, animated, known)
+		}
+	}
+}
+
+// An ugoira ZIP holding the given name and content pairs, in order
+func ugoiraFixture(t *testing.T, files ...[2]string) string {
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+	for _, file := range files {
+		w, err := archive.Create(file[0])
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		w.Write([]byte(file[1]))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "ugoira.zip")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	return path
+}
+
+func TestExtractUgoiraFrames(t *testing.T) {
+	zipPath := ugoiraFixture(t,
+		[2]string{"000000.jpg", "first"},
+		[2]string{"000001.jpg", "second"},
+		[2]string{"../../escape.jpg", "third"},
+	)
+
+	dir := t.TempDir()
+	paths, delays, err := media_processor.ExtractUgoiraFrames(zipPath, []social.UgoiraFrame{
+		{File: "000001.jpg", Delay: 60},
+		{File: "000000.jpg", Delay: 0},
+		{File: "escape.jpg", Delay: 120},
+	}, dir)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !slices.Equal(delays, []int{60, 100, 120}) {
+		t.Errorf("Expected the delays of the frame list, got %v", delays)
+	}
+	for i, expected := range []string{"second", "first", "third"} {
+		if filepath.Dir(paths[i]) != dir {
+			t.Errorf("Expected frame %d to be extracted into %s, got %s", i, dir, paths[i])
+		}
+		if data, err := os.ReadFile(paths[i]); err != nil || string(data) != expected {
+			t.Errorf("Expected frame %d to be %q, got %q, %v", i, expected, data, err)
+		}
+	}
+
+	// without a frame list, the archive's order is kept at the default pace
+	paths, delays, err = media_processor.ExtractUgoiraFrames(zipPath, nil, t.TempDir())
+	if err != nil || len(paths) != 3 || !slices.Equal(delays, []int{100, 100, 100}) {
+		t.Errorf("Expected 3 frames of 100ms, got %v, %v, %v", paths, delays, err)
+	}
+}
+
+func TestExtractUgoiraFramesRejectsMalformedFiles(t *testing.T) {
+	notZip := filepath.Join(t.TempDir(), "ugoira.zip")
+	if err := os.WriteFile(notZip, []byte("not a zip"), 0o600); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	cases := []struct {
+		name    string
+		zipPath string
+		frames  []social.UgoiraFrame
+	}{
+		{"not a ZIP", notZip, nil},
+		{"empty archive", ugoiraFixture(t), nil},
+		{"missing frame", ugoiraFixture(t, [2]string{"000000.jpg", "first"}), []social.UgoiraFrame{{File: "000001.jpg", Delay: 60}}},
+	}
+	for _, c := range cases {
+		if _, _, err := media_processor.ExtractUgoiraFrames(c.zipPath, c.frames, t.TempDir()); err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package media_processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"path/filepath"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// One chunk of a PNG file
+type pngChunk struct {
+	chunkType string
+	data      []byte
+}
+
+// Split a PNG file into chunks
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("readPNGChunks: not a PNG file")
+	}
+	data = data[len(pngSignature):]
+	chunks := make([]pngChunk, 0)
+	for len(data) >= 12 {
+		size := int(binary.BigEndian.Uint32(data[:4]))
+		if 12+size > len(data) {
+			return nil, fmt.Errorf("readPNGChunks: chunk %q is truncated", data[4:8])
+		}
+		chunks = append(chunks, pngChunk{chunkType: string(data[4:8]), data: data[8 : 8+size]})
+		data = data[12+size:]
+	}
+	return chunks, nil
+}
+
+// Write a chunk with its length and CRC
+func writePNGChunk(buf *bytes.Buffer, chunkType string, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.WriteString(chunkType)
+	buf.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// Tell from the first bytes of a PNG file whether it's animated, only the
+// chunk headers are read. `known` is false when the bytes end before it can
+// be told
+func sniffAPNG(data []byte) (animated bool, known bool) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return false, true
+	}
+	for offset := len(pngSignature); offset+8 <= len(data); {
+		switch string(data[offset+4 : offset+8]) {
+		case "acTL":
+			return true, true
+		case "IDAT", "IEND":
+			// acTL must come before the image data
+			return false, true
+		}
+		offset += 12 + int(binary.BigEndian.Uint32(data[offset:offset+4]))
+	}
+	return false, false
+}
+
+// Check whether a file is an animated PNG
+func isAnimatedPNG(data []byte) bool {
+	animated, _ := sniffAPNG(data)
+	return animated
+}
+
+// The frame control chunk of an APNG
+type apngFrameControl struct {
+	rect       image.Rectangle
+	delay      int
+	disposeOp  byte
+	blendOp    byte
+	dataChunks [][]byte
+}
+
+// Decode every frame of an APNG, composite them on a white canvas and save
+// them as PNG files into the directory
+func extractAPNGFrames(data []byte, dir string) ([]frame, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, fmt.Errorf("extractAPNGFrames: %w", err)
+	}
+
+	// collect the chunks shared by every frame and the frame controls
+	var ihdr []byte
+	shared := make([]pngChunk, 0)
+	controls := make([]*apngFrameControl, 0)
+	for _, chunk := range chunks {
+		switch chunk.chunkType {
+		case "IHDR":
+			ihdr = chunk.data
+		case "fcTL":
+			if len(chunk.data) < 26 {
+				return nil, fmt.Errorf("extractAPNGFrames: fcTL chunk is too short")
+			}
+			d := chunk.data
+			width, height := int(binary.BigEndian.Uint32(d[4:8])), int(binary.BigEndian.Uint32(d[8:12]))
+			x, y := int(binary.BigEndian.Uint32(d[12:16])), int(binary.BigEndian.Uint32(d[16:20]))
+			delayNum, delayDen := int(binary.BigEndian.Uint16(d[20:22])), int(binary.BigEndian.Uint16(d[22:24]))
+			if delayDen == 0 {
+				delayDen = 100
+			}
+			controls = append(controls, &apngFrameControl{
+				rect:      image.Rect(x, y, x+width, y+height),
+				delay:     frameDelay(delayNum * 1000 / delayDen),
+				disposeOp: d[24],
+				blendOp:   d[25],
+			})
+		case "IDAT":
+			// the default image is only part of the animation when a fcTL
+			// comes before it
+			if len(controls) > 0 {
+				controls[len(controls)-1].dataChunks = append(controls[len(controls)-1].dataChunks, chunk.data)
+			}
+		case "fdAT":
+			if len(controls) == 0 || len(chunk.data) < 4 {
+				return nil, fmt.Errorf("extractAPNGFrames: unexpected fdAT chunk")
+			}
+			controls[len(controls)-1].dataChunks = append(controls[len(controls)-1].dataChunks, chunk.data[4:])
+		case "PLTE", "tRNS", "gAMA", "cHRM", "sRGB", "iCCP":
+			shared = append(shared, chunk)
+		}
+	}
+	if len(ihdr) < 13 || len(controls) == 0 {
+		return nil, fmt.Errorf("extractAPNGFrames: no frame found")
+	}
+
+	width, height := int(binary.BigEndian.Uint32(ihdr[0:4])), int(binary.BigEndian.Uint32(ihdr[4:8]))
+	if err := checkCanvasSize(width, height); err != nil {
+		return nil, fmt.Errorf("extractAPNGFrames: %w", err)
+	}
+	canvasRect := image.Rect(0, 0, width, height)
+	for i, control := range controls {
+		if err := checkFrameBounds(control.rect, canvasRect); err != nil {
+			return nil, fmt.Errorf("extractAPNGFrames: frame %d: %w", i, err)
+		}
+	}
+	canvas := image.NewRGBA(canvasRect)
+	background := image.NewUniform(color.White)
+	draw.Draw(canvas, canvasRect, background, image.Point{}, draw.Src)
+
+	frames := make([]frame, 0, len(controls))
+	for i, control := range controls {
+		// rebuild a standalone PNG for this frame
+		buf := bytes.NewBuffer(append([]byte{}, pngSignature...))
+		frameIHDR := append([]byte{}, ihdr...)
+		binary.BigEndian.PutUint32(frameIHDR[0:4], uint32(control.rect.Dx()))
+		binary.BigEndian.PutUint32(frameIHDR[4:8], uint32(control.rect.Dy()))
+		writePNGChunk(buf, "IHDR", frameIHDR)
+		for _, chunk := range shared {
+			writePNGChunk(buf, chunk.chunkType, chunk.data)
+		}
+		for _, dataChunk := range control.dataChunks {
+			writePNGChunk(buf, "IDAT", dataChunk)
+		}
+		writePNGChunk(buf, "IEND", nil)
+		img, err := png.Decode(buf)
+		if err != nil {
+			return nil, fmt.Errorf("extractAPNGFrames: frame %d: %w", i, err)
+		}
+
+		// there's nothing to restore to before the first frame
+		if i == 0 && control.disposeOp == 2 {
+			control.disposeOp = 1
+		}
+
+		// keep what's under the frame if it has to be restored afterwards
+		var saved *image.RGBA
+		if control.disposeOp == 2 {
+			saved = image.NewRGBA(control.rect)
+			draw.Draw(saved, control.rect, canvas, control.rect.Min, draw.Src)
+		}
+
+		if control.blendOp == 0 {
+			draw.Draw(canvas, control.rect, background, image.Point{}, draw.Src)
+		}
+		draw.Draw(canvas, control.rect, img, img.Bounds().Min, draw.Over)
+
+		path := filepath.Join(dir, fmt.Sprintf("frame%04d.png", i))
+		if err := writePNG(path, canvas); err != nil {
+			return nil, fmt.Errorf("extractAPNGFrames: %w", err)
+		}
+		frames = append(frames, frame{path: path, delay: control.delay})
+
+		switch control.disposeOp {
+		case 1:
+			draw.Draw(canvas, control.rect, background, image.Point{}, draw.Src)
+		case 2:
+			draw.Draw(canvas, control.rect, saved, control.rect.Min, draw.Src)
+		}
+	}
+	return frames, nil
+}
@@ -0,0 +1,74 @@
+package media_processor
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// Telegram bots can't upload files larger than 50MB
+	maxDownloadSize = 50 * 1024 * 1024
+	// How long a download may take before it's given up, enough for 50MB
+	// from a slow server
+	downloadTimeout = 5 * time.Minute
+)
+
+// Shared by the downloads, so a stalled server doesn't block a worker forever
+var httpClient = &http.Client{Timeout: downloadTimeout}
+
+// Fetch the first bytes of a media URL, asking the server for only those
+func downloadHead(url_ string, size int) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url_, nil)
+	if err != nil {
+		return nil, fmt.Errorf("downloadHead: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", size-1))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("downloadHead: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("downloadHead: unexpected status %s", resp.Status)
+	}
+
+	// servers ignoring the range send everything, only the start is read
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(size)))
+	if err != nil {
+		return nil, fmt.Errorf("downloadHead: %w", err)
+	}
+	return data, nil
+}
+
+// Download a media URL into the directory, returning the path of the file
+func download(url_ string, dir string) (string, error) {
+	resp, err := httpClient.Get(url_)
+	if err != nil {
+		return "", fmt.Errorf("download: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download: unexpected status %s", resp.Status)
+	}
+
+	file, err := os.CreateTemp(dir, "*"+path.Ext(strings.Split(url_, "?")[0]))
+	if err != nil {
+		return "", fmt.Errorf("download: %w", err)
+	}
+	defer file.Close()
+
+	written, err := io.Copy(file, io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return "", fmt.Errorf("download: %w", err)
+	}
+	if written > maxDownloadSize {
+		return "", fmt.Errorf("download: file is larger than %dMB", maxDownloadSize/1024/1024)
+	}
+	return filepath.Clean(file.Name()), nil
+}
@@ -0,0 +1,34 @@
+package media_processor
+
+import "social-2-telego/social"
+
+// Unexported helpers used by the tests of package media_processor_test
+var (
+	IsFastStart    = isFastStart
+	SniffAnimation = sniffAnimation
+	HashImage      = hashImage
+)
+
+// Extract the frames of an APNG, returning their paths and delays
+func ExtractAPNGFrames(data []byte, dir string) ([]string, []int, error) {
+	return splitFrames(extractAPNGFrames(data, dir))
+}
+
+// Extract the frames of an animated WebP, returning their paths and delays
+func ExtractWebPFrames(data []byte, dir string) ([]string, []int, error) {
+	return splitFrames(extractWebPFrames(data, dir))
+}
+
+// Extract the frames of an ugoira ZIP, returning their paths and delays
+func ExtractUgoiraFrames(zipPath string, ugoiraFrames []social.UgoiraFrame, dir string) ([]string, []int, error) {
+	return splitFrames(extractUgoiraFrames(zipPath, ugoiraFrames, dir))
+}
+
+func splitFrames(frames []frame, err error) ([]string, []int, error) {
+	paths, delays := make([]string, 0), make([]int, 0)
+	for _, f := range frames {
+		paths = append(paths, f.path)
+		delays = append(delays, f.delay)
+	}
+	return paths, delays, err
+}
@@ -0,0 +1,47 @@
+package media_processor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Check whether the "moov" atom of an MP4/MOV file comes before the "mdat"
+// atom, which is what lets Telegram stream it and render a preview
+func isFastStart(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("isFastStart: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, 16)
+	var offset int64
+	for {
+		if _, err := file.ReadAt(header[:8], offset); err != nil {
+			return false, fmt.Errorf("isFastStart: no moov or mdat atom found: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		switch string(header[4:8]) {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+
+		switch size {
+		case 0: // the atom extends to the end of the file
+			return false, fmt.Errorf("isFastStart: no moov or mdat atom found")
+		case 1: // the real size is stored in the next 8 bytes
+			if _, err := file.ReadAt(header[8:16], offset+8); err != nil && err != io.EOF {
+				return false, fmt.Errorf("isFastStart: %w", err)
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if size < 8 {
+			return false, fmt.Errorf("isFastStart: not an MP4 file")
+		}
+		offset += size
+	}
+}
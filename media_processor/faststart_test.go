@@ -0,0 +1,62 @@
+package media_processor_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"social-2-telego/media_processor"
+	"testing"
+)
+
+// An MP4 atom with a 32-bit size and `payload` bytes of zeros
+func atom(kind string, payload int) []byte {
+	data := make([]byte, 8+payload)
+	binary.BigEndian.PutUint32(data, uint32(len(data)))
+	copy(data[4:8], kind)
+	return data
+}
+
+// An MP4 atom with its size in the 64-bit field
+func largeAtom(kind string, payload int) []byte {
+	data := make([]byte, 16+payload)
+	binary.BigEndian.PutUint32(data, 1)
+	copy(data[4:8], kind)
+	binary.BigEndian.PutUint64(data[8:16], uint64(len(data)))
+	return data
+}
+
+func join(atoms ...[]byte) []byte {
+	data := make([]byte, 0)
+	for _, a := range atoms {
+		data = append(data, a...)
+	}
+	return data
+}
+
+func TestIsFastStart(t *testing.T) {
+	cases := []struct {
+		name      string
+		data      []byte
+		fastStart bool
+		fails     bool
+	}{
+		{"moov first", join(atom("ftyp", 16), atom("moov", 32), atom("mdat", 64)), true, false},
+		{"mdat first", join(atom("ftyp", 16), atom("mdat", 64), atom("moov", 32)), false, false},
+		{"64-bit size", join(atom("ftyp", 16), largeAtom("free", 8), atom("moov", 32)), true, false},
+		{"no moov nor mdat", join(atom("ftyp", 16), atom("free", 8)), false, true},
+		{"truncated", join(atom("ftyp", 16))[:12], false, true},
+		{"size too small", []byte{0, 0, 0, 4, 'f', 't', 'y', 'p'}, false, true},
+		{"size to the end", []byte{0, 0, 0, 0, 'f', 'r', 'e', 'e'}, false, true},
+		{"empty", nil, false, true},
+	}
+	for _, c := range cases {
+		path := filepath.Join(t.TempDir(), "video.mp4")
+		if err := os.WriteFile(path, c.data, 0o600); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		fastStart, err := media_processor.IsFastStart(path)
+		if (err != nil) != c.fails || fastStart != c.fastStart {
+			t.Errorf("%s: expected %v (fails: %v), got %v, %v", c.name, c.fastStart, c.fails, fastStart, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package media_processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// How long a single ffmpeg or ffprobe run may take before being killed
+const ffmpegTimeout = 2 * time.Minute
+
+type probeResult struct {
+	Width    int
+	Height   int
+	Duration float64
+}
+
+// Run an ffmpeg-like command, returning its stdout and including its stderr in
+// the error when it fails
+func runCommand(name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ffmpegTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("%s: %w: %s", name, err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return output, nil
+}
+
+// Get the dimensions and the duration of the first video stream
+func (p *Processor) probe(path string) (probeResult, error) {
+	output, err := runCommand(p.appState.GetFfprobePath(),
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "json",
+		path,
+	)
+	if err != nil {
+		return probeResult{}, fmt.Errorf("probe: %w", err)
+	}
+
+	var parsed struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &parsed); err != nil {
+		return probeResult{}, fmt.Errorf("probe: %w", err)
+	}
+	if len(parsed.Streams) == 0 {
+		return probeResult{}, fmt.Errorf("probe: no video stream found")
+	}
+
+	// the duration is missing for some containers, it's optional anyway
+	duration, _ := strconv.ParseFloat(parsed.Format.Duration, 64)
+	return probeResult{
+		Width:    parsed.Streams[0].Width,
+		Height:   parsed.Streams[0].Height,
+		Duration: duration,
+	}, nil
+}
+
+// Move the moov atom to the front without re-encoding
+func (p *Processor) remux(input string, output string) error {
+	if _, err := runCommand(p.appState.GetFfmpegPath(),
+		"-y", "-v", "error",
+		"-i", input,
+		"-c", "copy",
+		"-movflags", "+faststart",
+		output,
+	); err != nil {
+		return fmt.Errorf("remux: %w", err)
+	}
+	return nil
+}
+
+// Extract a JPEG thumbnail that fits Telegram's 320x320 limit
+func (p *Processor) extractThumbnail(input string, output string, duration float64) error {
+	// the very first frame is often a black fade-in
+	seek := math.Min(1, duration/2)
+	if _, err := runCommand(p.appState.GetFfmpegPath(),
+		"-y", "-v", "error",
+		"-ss", strconv.FormatFloat(seek, 'f', 3, 64),
+		"-i", input,
+		"-frames:v", "1",
+		"-vf", "scale=320:320:force_original_aspect_ratio=decrease",
+		"-q:v", "4",
+		output,
+	); err != nil {
+		return fmt.Errorf("extractThumbnail: %w", err)
+	}
+	return nil
+}
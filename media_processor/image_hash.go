@@ -0,0 +1,41 @@
+package media_processor
+
+import (
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Compute the difference hash of an image: shrink it to 9x8 gray cells, then
+// set one bit per pair of horizontally adjacent cells, depending on which one
+// is brighter. Resized or recompressed copies end up only a few bits apart
+func DHash(img image.Image) uint64 {
+	const width, height = 9, 8
+	var sums [height][width]float64
+	var counts [height][width]float64
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		cellY := (y - bounds.Min.Y) * height / bounds.Dy()
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			cellX := (x - bounds.Min.X) * width / bounds.Dx()
+			r, g, b, _ := img.At(x, y).RGBA()
+			sums[cellY][cellX] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			counts[cellY][cellX]++
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if sums[y][x]*counts[y][x+1] > sums[y][x+1]*counts[y][x] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
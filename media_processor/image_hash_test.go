@@ -0,0 +1,37 @@
+package media_processor_test
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+	"social-2-telego/media_processor"
+	"testing"
+)
+
+// A horizontal gradient, flipped when `reverse` is set
+func gradient(width int, height int, reverse bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		value := uint8(x * 255 / width)
+		if reverse {
+			value = 255 - value
+		}
+		for y := 0; y < height; y++ {
+			img.SetGray(x, y, color.Gray{Y: value})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	original := media_processor.DHash(gradient(900, 800, false))
+	resized := media_processor.DHash(gradient(450, 400, false))
+	different := media_processor.DHash(gradient(900, 800, true))
+
+	if distance := bits.OnesCount64(original ^ resized); distance > 2 {
+		t.Errorf("Expected a resized copy to be within 2 bits, got %d", distance)
+	}
+	if distance := bits.OnesCount64(original ^ different); distance < 32 {
+		t.Errorf("Expected a different image to be far away, got %d", distance)
+	}
+}
@@ -0,0 +1,154 @@
+package media_processor
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"social-2-telego/social"
+	"social-2-telego/utils"
+)
+
+// Prepare scraped media for Telegram with a local ffmpeg before uploading
+type Processor struct {
+	appState *utils.AppState
+}
+
+// Create a new Processor instance
+func NewProcessor(appState *utils.AppState) *Processor {
+	return &Processor{appState: appState}
+}
+
+// Check whether ffmpeg and ffprobe are both available
+func (p *Processor) Enabled() bool {
+	return p.appState.GetFfmpegPath() != "" && p.appState.GetFfprobePath() != ""
+}
+
+// Download and post-process the media which need it. With `hash`, the photos
+// are hashed with the same download, otherwise still photos aren't downloaded
+// at all. Items that fail to be processed are kept as they are, so Telegram
+// can still fetch them by URL, and photos which can't be hashed are skipped.
+// The returned function removes the temporary files and must always be called
+// once the media are sent
+func (p *Processor) Process(media []social.ScrapedMedia, hash bool) ([]social.ScrapedMedia, []uint64, func(), error) {
+	noop := func() {}
+	dir, err := os.MkdirTemp("", "social-2-telego-")
+	if err != nil {
+		return fallback(media), nil, noop, fmt.Errorf("Processor.Process: %w", err)
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			slog.Warn("failed to remove temporary media", "dir", dir, "err", err)
+		}
+	}
+
+	result := make([]social.ScrapedMedia, 0, len(media))
+	hashes := make([]uint64, 0)
+	for i, item := range media {
+		itemDir := filepath.Join(dir, fmt.Sprintf("%d", i))
+
+		var processed social.ScrapedMedia
+		var err error
+		switch {
+		case item.MediaType == social.MediaTypePhoto && hash:
+			var photoHash uint64
+			var hashed bool
+			processed, photoHash, hashed, err = p.processPhoto(item, itemDir)
+			if hashed {
+				hashes = append(hashes, photoHash)
+			}
+		case item.MediaType == social.MediaTypePhoto && p.Enabled():
+			processed, err = p.processAnimatedImage(item, itemDir)
+		case item.MediaType == social.MediaTypeVideo && p.Enabled():
+			processed, err = p.processVideo(item, itemDir)
+		case item.MediaType == social.MediaTypeUgoira && p.Enabled():
+			processed, err = p.processUgoira(item, itemDir)
+		default:
+			processed = item
+		}
+		if err != nil {
+			slog.Warn("failed to process media, sending it as is", "url", item.MediaUrl, "err", err)
+			processed = item
+		}
+		result = append(result, processed)
+	}
+	return fallback(result), hashes, cleanup, nil
+}
+
+// Turn what's left unprocessed and can't be sent as is into something
+// Telegram accepts, an ugoira ZIP can at least be sent as a document
+func fallback(media []social.ScrapedMedia) []social.ScrapedMedia {
+	result := make([]social.ScrapedMedia, 0, len(media))
+	for _, item := range media {
+		if item.MediaType == social.MediaTypeUgoira {
+			item.MediaType = social.MediaTypeDocument
+			item.Frames = nil
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// Download a video, remux it if it's not faststart, then fill in its
+// dimensions, duration and thumbnail
+func (p *Processor) processVideo(item social.ScrapedMedia, dir string) (social.ScrapedMedia, error) {
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return item, fmt.Errorf("processVideo: %w", err)
+	}
+
+	path, err := download(item.MediaUrl, dir)
+	if err != nil {
+		return item, fmt.Errorf("processVideo: %w", err)
+	}
+
+	// only MP4-like containers have a moov atom, leave anything else alone
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".mp4" || ext == ".mov" || ext == ".m4v" {
+		if fastStart, err := isFastStart(path); err != nil {
+			slog.Debug("can't check for faststart", "url", item.MediaUrl, "err", err)
+		} else if !fastStart {
+			remuxed := filepath.Join(dir, "remuxed.mp4")
+			if err := p.remux(path, remuxed); err != nil {
+				return item, fmt.Errorf("processVideo: %w", err)
+			}
+			path = remuxed
+		}
+	}
+
+	item.FilePath = path
+	return p.describeVideo(item, dir)
+}
+
+// Fill in the dimensions, duration and thumbnail of a local video file
+func (p *Processor) describeVideo(item social.ScrapedMedia, dir string) (social.ScrapedMedia, error) {
+	probed, err := p.probe(item.FilePath)
+	if err != nil {
+		return item, fmt.Errorf("describeVideo: %w", err)
+	}
+
+	// prefer the preview chosen by the source, it still has to be scaled
+	// down to fit Telegram's limits
+	thumbnailSource, seek := item.FilePath, probed.Duration
+	if item.ThumbnailUrl != "" {
+		if path, err := download(item.ThumbnailUrl, dir); err != nil {
+			slog.Debug("can't download the thumbnail, extracting one instead", "url", item.ThumbnailUrl, "err", err)
+		} else {
+			thumbnailSource, seek = path, 0
+		}
+	}
+
+	thumbnail := filepath.Join(dir, "thumbnail.jpg")
+	if err := p.extractThumbnail(thumbnailSource, thumbnail, seek); err != nil {
+		slog.Warn("failed to extract thumbnail", "url", item.MediaUrl, "err", err)
+		thumbnail = ""
+	}
+
+	item.ThumbnailPath = thumbnail
+	item.Width = probed.Width
+	item.Height = probed.Height
+	item.Duration = int(math.Round(probed.Duration))
+	return item, nil
+}
@@ -0,0 +1,104 @@
+package media_processor_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"social-2-telego/media_processor"
+	"social-2-telego/social"
+	"social-2-telego/utils"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProcessHashesPhotosWithOneDownload(t *testing.T) {
+	img := gradient(90, 80, false)
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	t.Setenv("BOT_TOKEN", "token")
+	t.Setenv("ARTIST_DB_DOMAIN", "https://artistdb.example.com/{username}")
+	processor := media_processor.NewProcessor(utils.NewAppState())
+	photo := social.ScrapedMedia{MediaType: social.MediaTypePhoto, MediaUrl: server.URL + "/1.png"}
+	media, hashes, cleanup, err := processor.Process([]social.ScrapedMedia{photo}, true)
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if !slices.Equal(hashes, []uint64{media_processor.DHash(img)}) {
+		t.Errorf("Expected the hash of the photo, got %v", hashes)
+	}
+	if len(media) != 1 || !reflect.DeepEqual(media[0], photo) {
+		t.Errorf("Expected the still photo to be sent by URL, got %+v", media)
+	}
+	if n := downloads.Load(); n != 1 {
+		t.Errorf("Expected one download, got %d", n)
+	}
+}
+
+func TestProcessOnlySniffsPhotosWithoutHashing(t *testing.T) {
+	ihdr, idat := encodePNG(t, 4, 4, red)
+	still := slices.Concat([]byte("\x89PNG\r\n\x1a\n"), pngChunk("IHDR", ihdr), pngChunk("IDAT", idat), pngChunk("IEND", nil))
+	var requests atomic.Int32
+	var ranged atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		ranged.Store(r.Header.Get("Range") != "")
+		w.Write(still)
+	}))
+	defer server.Close()
+
+	// any executable makes the processor think ffmpeg is there
+	t.Setenv("BOT_TOKEN", "token")
+	t.Setenv("ARTIST_DB_DOMAIN", "https://artistdb.example.com/{username}")
+	t.Setenv("FFMPEG_PATH", "true")
+	t.Setenv("FFPROBE_PATH", "true")
+	processor := media_processor.NewProcessor(utils.NewAppState())
+	photos := []social.ScrapedMedia{
+		{MediaType: social.MediaTypePhoto, MediaUrl: server.URL + "/1.jpg"},
+		{MediaType: social.MediaTypePhoto, MediaUrl: server.URL + "/2.png"},
+	}
+	media, hashes, cleanup, err := processor.Process(photos, false)
+	defer cleanup()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if len(hashes) != 0 || !reflect.DeepEqual(media, photos) {
+		t.Errorf("Expected the photos untouched and unhashed, got %+v, %v", media, hashes)
+	}
+	if requests.Load() != 1 || !ranged.Load() {
+		t.Errorf("Expected only the first bytes of the PNG to be asked for, got %d requests", requests.Load())
+	}
+}
+
+func TestHashImageRefusesHugeCanvases(t *testing.T) {
+	ihdr, idat := encodePNG(t, 4, 4, red)
+	png := func(ihdr []byte) []byte {
+		return slices.Concat([]byte("\x89PNG\r\n\x1a\n"), pngChunk("IHDR", ihdr), pngChunk("IDAT", idat), pngChunk("IEND", nil))
+	}
+	if _, err := media_processor.HashImage(png(ihdr)); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	// a few bytes declaring a canvas which would take gigabytes to decode
+	huge := bytes.Clone(ihdr)
+	binary.BigEndian.PutUint32(huge[0:], 100000)
+	binary.BigEndian.PutUint32(huge[4:], 100000)
+	if _, err := media_processor.HashImage(png(huge)); err == nil {
+		t.Error("Expected a huge canvas not to be hashed")
+	}
+}
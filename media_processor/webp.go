@@ -0,0 +1,191 @@
+package media_processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/image/webp"
+)
+
+// One chunk of a RIFF container
+type riffChunk struct {
+	fourCC string
+	data   []byte
+}
+
+// Split the payload of a RIFF container into chunks
+func readRiffChunks(payload []byte) ([]riffChunk, error) {
+	chunks := make([]riffChunk, 0)
+	for len(payload) >= 8 {
+		size := int(binary.LittleEndian.Uint32(payload[4:8]))
+		if 8+size > len(payload) {
+			return nil, fmt.Errorf("readRiffChunks: chunk %q is truncated", payload[:4])
+		}
+		chunks = append(chunks, riffChunk{fourCC: string(payload[:4]), data: payload[8 : 8+size]})
+		// chunks are padded to an even size
+		payload = payload[min(len(payload), 8+size+size%2):]
+	}
+	return chunks, nil
+}
+
+// Wrap chunks into a standalone RIFF WebP file
+func writeRiffWebP(chunks ...riffChunk) []byte {
+	body := bytes.NewBufferString("WEBP")
+	for _, chunk := range chunks {
+		body.WriteString(chunk.fourCC)
+		binary.Write(body, binary.LittleEndian, uint32(len(chunk.data)))
+		body.Write(chunk.data)
+		if len(chunk.data)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	file := bytes.NewBufferString("RIFF")
+	binary.Write(file, binary.LittleEndian, uint32(body.Len()))
+	file.Write(body.Bytes())
+	return file.Bytes()
+}
+
+// Read a little endian 24 bits integer
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// Tell from the first bytes of a WebP file whether it's animated, which the
+// flags of its VP8X chunk say. `known` is false when the bytes end before
+// them
+func sniffAnimatedWebP(data []byte) (animated bool, known bool) {
+	if len(data) < 16 {
+		return false, false
+	}
+	// only the extended format can be animated
+	if string(data[12:16]) != "VP8X" {
+		return false, true
+	}
+	if len(data) < 21 {
+		return false, false
+	}
+	return data[20]&0x02 != 0, true
+}
+
+// Check whether a file is an animated WebP
+func isAnimatedWebP(data []byte) bool {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return false
+	}
+	animated, _ := sniffAnimatedWebP(data)
+	return animated
+}
+
+// Decode every frame of an animated WebP, composite them on a white canvas
+// and save them as PNG files into the directory
+func extractWebPFrames(data []byte, dir string) ([]frame, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("extractWebPFrames: not a WebP file")
+	}
+	chunks, err := readRiffChunks(data[12:])
+	if err != nil {
+		return nil, fmt.Errorf("extractWebPFrames: %w", err)
+	}
+
+	var canvas *image.RGBA
+	background := image.NewUniform(color.White)
+	var previous image.Rectangle
+	disposePrevious := false
+	frames := make([]frame, 0)
+	for _, chunk := range chunks {
+		switch chunk.fourCC {
+		case "VP8X":
+			if len(chunk.data) < 10 {
+				return nil, fmt.Errorf("extractWebPFrames: VP8X chunk is too short")
+			}
+			width, height := uint24(chunk.data[4:7])+1, uint24(chunk.data[7:10])+1
+			if err := checkCanvasSize(width, height); err != nil {
+				return nil, fmt.Errorf("extractWebPFrames: %w", err)
+			}
+			bounds := image.Rect(0, 0, width, height)
+			canvas = image.NewRGBA(bounds)
+			draw.Draw(canvas, bounds, background, image.Point{}, draw.Src)
+			continue
+		case "ANMF":
+		default:
+			continue
+		}
+
+		if canvas == nil {
+			return nil, fmt.Errorf("extractWebPFrames: ANMF chunk before VP8X")
+		}
+		if len(chunk.data) < 16 {
+			return nil, fmt.Errorf("extractWebPFrames: ANMF chunk is too short")
+		}
+		header := chunk.data[:16]
+		x, y := uint24(header[0:3])*2, uint24(header[3:6])*2
+		width, height := uint24(header[6:9])+1, uint24(header[9:12])+1
+		duration := frameDelay(uint24(header[12:15]))
+		rect := image.Rect(x, y, x+width, y+height)
+		if err := checkFrameBounds(rect, canvas.Bounds()); err != nil {
+			return nil, fmt.Errorf("extractWebPFrames: frame %d: %w", len(frames), err)
+		}
+		noBlend := header[15]&0x02 != 0
+		disposeBackground := header[15]&0x01 != 0
+
+		// the frame data is a still image made of an optional ALPH chunk
+		// followed by a VP8 or VP8L chunk
+		frameChunks, err := readRiffChunks(chunk.data[16:])
+		if err != nil {
+			return nil, fmt.Errorf("extractWebPFrames: %w", err)
+		}
+		hasAlpha := false
+		for _, frameChunk := range frameChunks {
+			hasAlpha = hasAlpha || frameChunk.fourCC == "ALPH"
+		}
+		if hasAlpha {
+			vp8x := make([]byte, 10)
+			vp8x[0] = 0x10
+			vp8x[4], vp8x[5], vp8x[6] = byte(width-1), byte((width-1)>>8), byte((width-1)>>16)
+			vp8x[7], vp8x[8], vp8x[9] = byte(height-1), byte((height-1)>>8), byte((height-1)>>16)
+			frameChunks = append([]riffChunk{{fourCC: "VP8X", data: vp8x}}, frameChunks...)
+		}
+		img, err := webp.Decode(bytes.NewReader(writeRiffWebP(frameChunks...)))
+		if err != nil {
+			return nil, fmt.Errorf("extractWebPFrames: %w", err)
+		}
+
+		// apply the disposal of the previous frame, then draw this one
+		if disposePrevious {
+			draw.Draw(canvas, previous, background, image.Point{}, draw.Src)
+		}
+		if noBlend {
+			draw.Draw(canvas, rect, background, image.Point{}, draw.Src)
+		}
+		draw.Draw(canvas, rect, img, img.Bounds().Min, draw.Over)
+		previous, disposePrevious = rect, disposeBackground
+
+		path := filepath.Join(dir, fmt.Sprintf("frame%04d.png", len(frames)))
+		if err := writePNG(path, canvas); err != nil {
+			return nil, fmt.Errorf("extractWebPFrames: %w", err)
+		}
+		frames = append(frames, frame{path: path, delay: duration})
+	}
+
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("extractWebPFrames: no frame found")
+	}
+	return frames, nil
+}
+
+// Save an image as a PNG file
+func writePNG(path string, img image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return png.Encode(file, img)
+}
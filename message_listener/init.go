@@ -1,23 +1,56 @@
 package message_listener
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
+	"social-2-telego/database"
 	"social-2-telego/utils"
+	"strings"
+	"time"
 )
 
+// Handle the updates which aren't links to post
+type Handlers struct {
+	// Messages starting with "/"
+	Command func(msg utils.IncomingMessage)
+	// Replies to the bot's messages, returns false to queue them as links
+	Reply func(msg utils.IncomingMessage) bool
+	// Presses of inline keyboard buttons
+	Callback func(query utils.CallbackQuery)
+	// Messages of users who aren't allowed to use the bot
+	Unauthorized func(msg utils.IncomingMessage)
+	// Presses of buttons by users who aren't allowed to use the bot
+	UnauthorizedCallback func(query utils.CallbackQuery)
+}
+
+// Shared by the calls to the Bot API, so the listener never waits on a
+// stalled one forever
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// One update from Telegram, only one of its fields is set
+type update struct {
+	UpdateID      int                    `json:"update_id"`
+	Message       *utils.IncomingMessage `json:"message"`
+	CallbackQuery *utils.CallbackQuery   `json:"callback_query"`
+}
+
 type MessageListener struct {
 	appState     *utils.AppState
+	db           *database.Database
+	handlers     Handlers
 	offset       int
 	webhookToken string
 }
 
 // Either launch a http server for webhook
 // or poll updates from Telegram's servers
-func InitMessageListener(appState *utils.AppState) {
+func InitMessageListener(appState *utils.AppState, db *database.Database, handlers Handlers) {
 	ml := &MessageListener{
 		offset:   0,
 		appState: appState,
+		db:       db,
+		handlers: handlers,
 	}
 	switch ml.appState.GetUseWebhook() {
 	case true:
@@ -30,6 +63,59 @@ func InitMessageListener(appState *utils.AppState) {
 	case false:
 		slog.Info("polling updates")
 		ml.deleteWebhook()
-		go ml.GetUpdates()
+		ml.GetUpdates()
+	}
+}
+
+// Route an update to its handler, messages are dispatched below. Button
+// presses go through the same gate as messages. An error means the update
+// couldn't be queued and must be received again
+func (ml *MessageListener) dispatchUpdate(u update) error {
+	switch {
+	case u.CallbackQuery != nil && !ml.appState.IsAuthorized(u.CallbackQuery.From.Username):
+		go ml.handlers.UnauthorizedCallback(*u.CallbackQuery)
+	case u.CallbackQuery != nil:
+		go ml.handlers.Callback(*u.CallbackQuery)
+	case u.Message != nil && u.Message.Text != "":
+		return ml.dispatch(*u.Message)
+	}
+	return nil
+}
+
+// Turn unauthorized users away, run a command in the background, hand a
+// reply to the bot over, or split a message into one job per non-empty line
+// and queue them durably, so Telegram can be acknowledged right away either
+// way
+func (ml *MessageListener) dispatch(msg utils.IncomingMessage) error {
+	if !ml.appState.IsAuthorized(msg.From.Username) {
+		go ml.handlers.Unauthorized(msg)
+		return nil
+	}
+	if strings.HasPrefix(msg.Text, "/") {
+		go ml.handlers.Command(msg)
+		return nil
+	}
+	if msg.ReplyToMessage != nil && ml.handlers.Reply(msg) {
+		return nil
+	}
+
+	messages := make([]utils.IncomingMessage, 0)
+	for _, line := range strings.Split(msg.Text, "\n") {
+		if line == "" {
+			continue
+		}
+		messages = append(messages, utils.IncomingMessage{
+			MessageID: msg.MessageID,
+			Chat:      msg.Chat,
+			Text:      line,
+			From:      msg.From,
+		})
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	if err := ml.db.EnqueueJobs(messages); err != nil {
+		return fmt.Errorf("failed to queue message: %w", err)
 	}
+	return nil
 }
@@ -5,16 +5,13 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"net/http"
-	"social-2-telego/utils"
-	"strings"
 	"time"
 )
 
 // Get one single update
 func (ml *MessageListener) getOneUpdate() {
 	path_ := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates?offset=%d", ml.appState.GetBotToken(), ml.offset)
-	resp, err := http.Get(path_)
+	resp, err := httpClient.Get(path_)
 	if err != nil {
 		slog.Error("failed to request to get updates: ", "err", err)
 		return
@@ -27,33 +24,22 @@ func (ml *MessageListener) getOneUpdate() {
 	}
 
 	var respBody struct {
-		Ok     bool `json:"ok"`
-		Result []struct {
-			UpdateID int                   `json:"update_id"`
-			Message  utils.IncomingMessage `json:"message"`
-		} `json:"result"`
+		Ok     bool     `json:"ok"`
+		Result []update `json:"result"`
 	}
 	if err = json.Unmarshal(body, &respBody); err != nil {
 		slog.Error("failed to unmarshal response body: ", "err", err)
 		return
 	}
 
-	if len(respBody.Result) > 0 {
-		ml.offset = respBody.Result[len(respBody.Result)-1].UpdateID + 1
-	}
-
+	// only move past the updates which were dispatched, the others are
+	// received again on the next poll
 	for _, result := range respBody.Result {
-		messages := strings.Split(result.Message.Text, "\n")
-		for _, message := range messages {
-			if message == "" {
-				continue
-			}
-			ml.appState.MsgQueue <- utils.IncomingMessage{
-				Chat: result.Message.Chat,
-				Text: message,
-				From: result.Message.From,
-			}
+		if err := ml.dispatchUpdate(result); err != nil {
+			slog.Error("failed to dispatch update", "update_id", result.UpdateID, "err", err)
+			return
 		}
+		ml.offset = result.UpdateID + 1
 	}
 }
 
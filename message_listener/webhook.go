@@ -7,8 +7,6 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
-	"social-2-telego/utils"
-	"strings"
 )
 
 func (ml *MessageListener) setWebhook() {
@@ -17,7 +15,7 @@ func (ml *MessageListener) setWebhook() {
 		slog.Info("setting webhook", "url", webhookUrl)
 
 		// create a request
-		resp, err := http.PostForm(
+		resp, err := httpClient.PostForm(
 			"https://api.telegram.org/bot"+ml.appState.GetBotToken()+"/setWebhook",
 			url.Values{
 				"url":          {webhookUrl},
@@ -58,7 +56,7 @@ func (ml *MessageListener) setWebhook() {
 
 func (ml *MessageListener) deleteWebhook() {
 	// create the request
-	resp, err := http.PostForm(
+	resp, err := httpClient.PostForm(
 		"https://api.telegram.org/bot"+ml.appState.GetBotToken()+"/deleteWebhook",
 		url.Values{},
 	)
@@ -106,27 +104,16 @@ func (ml *MessageListener) handleWebhookRequest(w http.ResponseWriter, r *http.R
 		}
 	}
 
-	// parse, check non-empty request body, send to channel
-	incoming := struct {
-		UpdateID int                   `json:"update_id"`
-		Message  utils.IncomingMessage `json:"message"`
-	}{}
+	// parse, check non-empty request body, queue it
+	incoming := update{}
 	if err := json.NewDecoder(r.Body).Decode(&incoming); err != nil {
 		slog.Error("failed to decode webhook request body: ", "err", err)
-	}
-	if incoming.Message.Text == "" {
 		return
 	}
 
-	messages := strings.Split(incoming.Message.Text, "\n")
-	for _, message := range messages {
-		if message == "" {
-			continue
-		}
-		ml.appState.MsgQueue <- utils.IncomingMessage{
-			Chat: incoming.Message.Chat,
-			Text: message,
-			From: incoming.Message.From,
-		}
+	// Telegram sends the update again when it's not acknowledged
+	if err := ml.dispatchUpdate(incoming); err != nil {
+		slog.Error("failed to dispatch update", "update_id", incoming.UpdateID, "err", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
 	}
 }
@@ -2,6 +2,7 @@ package social
 
 import (
 	"fmt"
+	"html"
 	"io"
 	"net/http"
 	"regexp"
@@ -14,6 +15,8 @@ var (
 	faContentRegex = regexp.MustCompile(`(<div class="submission-description.+?>)((.|\n)*?)(</div>)`)
 	faDownloadUrl  = regexp.MustCompile(`<div class="download"><a href="(.+?)">.+?</div>`)
 	faUsernameRgx  = regexp.MustCompile(`submission-id-sub-container(.|\n)+?<strong>(.+?)</strong>`)
+	faRatingRgx    = regexp.MustCompile(`class="rating-box[^"]*">\s*(\w+)`)
+	faTitleRgx     = regexp.MustCompile(`class="submission-title">\s*<h2><p>(.*?)</p></h2>`)
 )
 
 type FA struct {
@@ -36,6 +39,16 @@ func (f *FA) SetURL(url_ string) error {
 	return nil
 }
 
+// Get the URL of the post without any trailing path or query
+func (f *FA) GetCanonicalURL() string {
+	return faPostUrlRegex.FindString(f.url) + "/"
+}
+
+// Get the short name of the site
+func (f *FA) GetSource() string {
+	return "fa"
+}
+
 // Scrape and save in `rawContent`
 func (f *FA) scrape() error {
 	// required condition
@@ -63,11 +76,14 @@ func (f *FA) scrape() error {
 	req.AddCookie(&http.Cookie{Name: "b", Value: cookieB, Path: "/"})
 
 	// do the request & read the response
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("FA.scrape: %w", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("FA.scrape: %w", utils.UnexpectedStatus(resp))
+	}
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("FA.scrape: %w", err)
@@ -128,6 +144,38 @@ func (f *FA) GetUsername() (string, error) {
 	return slice[2], nil
 }
 
+// FA doesn't show display names on posts, the username is used instead
+func (f *FA) GetDisplayName() (string, error) {
+	username, err := f.GetUsername()
+	if err != nil {
+		return "", fmt.Errorf("FA.GetDisplayName: %w", err)
+	}
+	return username, nil
+}
+
+// Get the link to the author's userpage, FA's URLs use lowercase usernames
+func (f *FA) GetProfileURL() (string, error) {
+	username, err := f.GetUsername()
+	if err != nil {
+		return "", fmt.Errorf("FA.GetProfileURL: %w", err)
+	}
+	return fmt.Sprintf("https://www.furaffinity.net/user/%s/", strings.ToLower(username)), nil
+}
+
+// Get the post's title from `rawContent`
+func (f *FA) GetTitle() (string, error) {
+	if f.rawContent == "" {
+		if err := f.scrape(); err != nil {
+			return "", fmt.Errorf("FA.GetTitle: %w", err)
+		}
+	}
+	slice := faTitleRgx.FindStringSubmatch(f.rawContent)
+	if len(slice) < 2 {
+		return "", nil
+	}
+	return html.UnescapeString(strings.TrimSpace(slice[1])), nil
+}
+
 // Get the media urls of the post from `rawContent`
 func (f *FA) GetMedia() ([]ScrapedMedia, error) {
 	if f.rawContent == "" {
@@ -148,10 +196,34 @@ func (f *FA) GetMedia() ([]ScrapedMedia, error) {
 		mediaUrl = "https:" + mediaUrl
 	}
 
+	// FA also hosts stories, music and flash, not just images
 	return []ScrapedMedia{
 		{
-			MediaType: MediaTypePhoto,
+			MediaType: MediaTypeFromURL(mediaUrl),
 			MediaUrl:  mediaUrl,
 		},
 	}, nil
 }
+
+// Get the rating of the post from `rawContent`, FA's "Adult" is explicit
+func (f *FA) GetRating() (string, error) {
+	if f.rawContent == "" {
+		if err := f.scrape(); err != nil {
+			return "", fmt.Errorf("FA.GetRating: %w", err)
+		}
+	}
+	slice := faRatingRgx.FindStringSubmatch(f.rawContent)
+	if len(slice) < 2 {
+		return "", nil
+	}
+	switch strings.ToLower(slice[1]) {
+	case "general":
+		return RatingGeneral, nil
+	case "mature":
+		return RatingMature, nil
+	case "adult":
+		return RatingExplicit, nil
+	default:
+		return "", nil
+	}
+}
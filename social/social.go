@@ -1,11 +1,18 @@
 package social
 
 import (
+	"net/http"
+	"path"
 	"regexp"
 	"social-2-telego/utils"
 	"strings"
+	"time"
 )
 
+// Shared by the scrapers, so a site which stops answering doesn't block a
+// worker forever
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
 var (
 	htmlUrlRgx            = regexp.MustCompile(`<a href="([^"]+)"[^>]*>([^<]+)</a>`)
 	htmlUrlPlaceholderRgx = regexp.MustCompile(`HLSTART ([^ ]+) HLSPLIT ([^ ]+) HLEND`)
@@ -15,22 +22,85 @@ var (
 type Social interface {
 	SetAppState(appState *utils.AppState)
 	SetURL(url string) error
+	// The URL of the post, identical no matter how the post was linked
+	GetCanonicalURL() string
+	// A short name of the site, e.g. "x"
+	GetSource() string
 
 	GetMarkdownContent() (func(string) string, error)
 	GetUsername() (string, error)
+	// The title of the post, empty when the site doesn't have titles
+	GetTitle() (string, error)
+	// The author's name as shown on the site and the link to their profile
+	GetDisplayName() (string, error)
+	GetProfileURL() (string, error)
 	GetMedia() ([]ScrapedMedia, error)
+	// How explicit the post is, one of the Rating constants, empty when the
+	// site doesn't tell
+	GetRating() (string, error)
 }
 
+const (
+	RatingGeneral  = "general"
+	RatingMature   = "mature"
+	RatingExplicit = "explicit"
+)
+
 type MediaType string
 
 const (
-	MediaTypePhoto MediaType = "photo"
-	MediaTypeVideo MediaType = "video"
+	MediaTypePhoto     MediaType = "photo"
+	MediaTypeVideo     MediaType = "video"
+	MediaTypeAnimation MediaType = "animation"
+	MediaTypeDocument  MediaType = "document"
+	MediaTypeAudio     MediaType = "audio"
+	// A ZIP of still frames, converted to an animation before sending
+	MediaTypeUgoira MediaType = "ugoira"
 )
 
+// One frame of an ugoira, the delay is in milliseconds
+type UgoiraFrame struct {
+	File  string
+	Delay int
+}
+
 type ScrapedMedia struct {
 	MediaType MediaType
 	MediaUrl  string
+	// The description of the media for accessibility, empty if there's none
+	AltText string
+	// A preview image of a video provided by the source, optional
+	ThumbnailUrl string
+	// Dimensions and duration in seconds, when the source provides them
+	Width    int
+	Height   int
+	Duration int
+
+	// Filled in by the media processing stage, a local file is uploaded
+	// instead of letting Telegram fetch MediaUrl
+	FilePath      string
+	ThumbnailPath string
+
+	// The frame list of an ugoira, in order
+	Frames []UgoiraFrame
+}
+
+// Guess the media type from the file extension of a media URL, anything
+// unknown is sent as a document so Telegram never rejects it
+func MediaTypeFromURL(url string) MediaType {
+	ext := strings.ToLower(path.Ext(strings.Split(url, "?")[0]))
+	switch ext {
+	case ".jpg", ".jpeg", ".png", ".webp":
+		return MediaTypePhoto
+	case ".mp4", ".mov", ".webm":
+		return MediaTypeVideo
+	case ".gif":
+		return MediaTypeAnimation
+	case ".mp3", ".m4a", ".ogg", ".wav", ".flac":
+		return MediaTypeAudio
+	default:
+		return MediaTypeDocument
+	}
 }
 
 func NewSocialInstance(url string) Social {
@@ -20,3 +20,27 @@ func TestValidateTwitter(t *testing.T) {
 		t.Errorf("Expected error, got nil")
 	}
 }
+
+func TestMediaTypeFromURL(t *testing.T) {
+	cases := map[string]social.MediaType{
+		"https://d.furaffinity.net/art/foo/1/1.foo.png":     social.MediaTypePhoto,
+		"https://d.furaffinity.net/art/foo/1/1.foo.GIF":     social.MediaTypeAnimation,
+		"https://d.furaffinity.net/art/foo/1/1.foo.mp3":     social.MediaTypeAudio,
+		"https://d.furaffinity.net/art/foo/1/1.foo.pdf?x=1": social.MediaTypeDocument,
+	}
+	for url, expected := range cases {
+		if got := social.MediaTypeFromURL(url); got != expected {
+			t.Errorf("%s: expected %s, got %s", url, expected, got)
+		}
+	}
+}
+
+func TestXCanonicalURL(t *testing.T) {
+	instance := social.X{}
+	if err := instance.SetURL("https://twitter.com/LoremIpsum/status/1234567890/photo/1?s=20"); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if got := instance.GetCanonicalURL(); got != "https://x.com/i/status/1234567890" {
+		t.Errorf("Unexpected canonical URL %s", got)
+	}
+}
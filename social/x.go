@@ -1,9 +1,11 @@
 package social
 
 import (
+	"encoding/json"
 	"fmt"
 	"html"
 	"io"
+	"math"
 	"net/http"
 	"regexp"
 	"social-2-telego/utils"
@@ -14,16 +16,41 @@ var (
 	xPostUrlRegex = regexp.MustCompile(`https:\/\/((twitter)|x).com\/([\w_]{1,15})\/status\/\d+`)
 	xContentRegex = regexp.MustCompile(`(<!-- Embed Status text -->)(.*?)(<!--)`)
 	xRootDomain   = regexp.MustCompile(`(x|twitter).com`)
-	xVideoRgx     = regexp.MustCompile(`<video src="([^"]+)"`)
+	xStatusIDRgx  = regexp.MustCompile(`/status/(\d+)`)
 )
 
 type X struct {
 	appState *utils.AppState
 
 	rawContent string
+	apiContent *xAPIResponse
 	url        string
 }
 
+// The parts of the fxtwitter API response that are used
+type xAPIResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Tweet   struct {
+		PossiblySensitive bool `json:"possibly_sensitive"`
+		Author            struct {
+			Name       string `json:"name"`
+			ScreenName string `json:"screen_name"`
+		} `json:"author"`
+		Media struct {
+			All []struct {
+				Type         string  `json:"type"`
+				URL          string  `json:"url"`
+				ThumbnailURL string  `json:"thumbnail_url"`
+				AltText      string  `json:"altText"`
+				Width        int     `json:"width"`
+				Height       int     `json:"height"`
+				Duration     float64 `json:"duration"`
+			} `json:"all"`
+		} `json:"media"`
+	} `json:"tweet"`
+}
+
 func (t *X) SetAppState(appState *utils.AppState) {
 	t.appState = appState
 }
@@ -37,6 +64,21 @@ func (t *X) SetURL(url_ string) error {
 	return nil
 }
 
+// Get the URL of the post without the username, which can be changed or typed
+// in any case, and without any trailing path or query
+func (t *X) GetCanonicalURL() string {
+	slice := xStatusIDRgx.FindStringSubmatch(t.url)
+	if len(slice) < 2 {
+		return t.url
+	}
+	return "https://x.com/i/status/" + slice[1]
+}
+
+// Get the short name of the site
+func (t *X) GetSource() string {
+	return "x"
+}
+
 // Scrape and save in `rawContent`
 func (t *X) scrape() error {
 	if t.url == "" {
@@ -50,11 +92,14 @@ func (t *X) scrape() error {
 	}
 
 	req.Header.Set("User-Agent", "TelegramBot (like TwitterBot)")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("x.scrape: %w", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("x.scrape: %w", utils.UnexpectedStatus(resp))
+	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -116,46 +161,108 @@ func (t *X) GetUsername() (string, error) {
 	return slice[3], nil
 }
 
-// Get the media urls of the post from `rawContent`
-func (t *X) GetMedia() ([]ScrapedMedia, error) {
-	if t.rawContent == "" {
-		if err := t.scrape(); err != nil {
-			return nil, fmt.Errorf("x.GetMedia: %w", err)
-		}
+// Fetch the post from the fxtwitter API, which unlike the embed page carries
+// the alt texts, dimensions and thumbnails of the media
+func (t *X) scrapeAPI() error {
+	if t.url == "" {
+		return fmt.Errorf("x.scrapeAPI: url is not set")
 	}
 
-	// Get the section containing media
-	start := "<!-- Embed media -->"
-	startIndex := strings.Index(t.rawContent, start) + len(start)
-	endIndex := strings.Index(t.rawContent[startIndex:], "<!--")
-	if startIndex < 0 || endIndex < 0 {
-		return nil, fmt.Errorf("x.GetMedia: media not found")
+	path := xRootDomain.ReplaceAllString(t.url, "api.fxtwitter.com")
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return fmt.Errorf("x.scrapeAPI: %w", err)
 	}
-	content := t.rawContent[startIndex : startIndex+endIndex]
 
-	result := make([]ScrapedMedia, 0)
-
-	// Get all the images
-	pattern, err := regexp.Compile(`<img src="([^"]+)" />`)
+	req.Header.Set("User-Agent", "TelegramBot (like TwitterBot)")
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("x.GetMedia: %w", err)
+		return fmt.Errorf("x.scrapeAPI: %w", err)
 	}
-	matches := pattern.FindAllStringSubmatch(content, -1)
-	for _, v := range matches {
-		result = append(result, ScrapedMedia{
-			MediaType: MediaTypePhoto,
-			MediaUrl:  v[1],
-		})
+	defer resp.Body.Close()
+	// errors like a missing post still come with a JSON body
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return fmt.Errorf("x.scrapeAPI: %w", utils.UnexpectedStatus(resp))
+	}
+
+	var body xAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("x.scrapeAPI: %w", err)
+	}
+	if body.Code != http.StatusOK {
+		return fmt.Errorf("x.scrapeAPI: %w", utils.HTTPStatusError(body.Code, fmt.Errorf("%d %s", body.Code, body.Message)))
 	}
 
-	// Get all the videos
-	matches = xVideoRgx.FindAllStringSubmatch(content, -1)
-	for _, v := range matches {
+	t.apiContent = &body
+	return nil
+}
+
+// Get the media of the post from the fxtwitter API
+func (t *X) GetMedia() ([]ScrapedMedia, error) {
+	if t.apiContent == nil {
+		if err := t.scrapeAPI(); err != nil {
+			return nil, fmt.Errorf("x.GetMedia: %w", err)
+		}
+	}
+
+	result := make([]ScrapedMedia, 0)
+	for _, media := range t.apiContent.Tweet.Media.All {
+		mediaType := map[string]MediaType{
+			"photo": MediaTypePhoto,
+			"video": MediaTypeVideo,
+			"gif":   MediaTypeAnimation,
+		}[media.Type]
+		if mediaType == "" {
+			continue
+		}
 		result = append(result, ScrapedMedia{
-			MediaType: MediaTypeVideo,
-			MediaUrl:  v[1],
+			MediaType:    mediaType,
+			MediaUrl:     media.URL,
+			AltText:      media.AltText,
+			ThumbnailUrl: media.ThumbnailURL,
+			Width:        media.Width,
+			Height:       media.Height,
+			Duration:     int(math.Round(media.Duration)),
 		})
 	}
 
 	return result, nil
 }
+
+// Get the author's name from the fxtwitter API
+func (t *X) GetDisplayName() (string, error) {
+	if t.apiContent == nil {
+		if err := t.scrapeAPI(); err != nil {
+			return "", fmt.Errorf("x.GetDisplayName: %w", err)
+		}
+	}
+	return t.apiContent.Tweet.Author.Name, nil
+}
+
+// Get the link to the author's profile
+func (t *X) GetProfileURL() (string, error) {
+	username, err := t.GetUsername()
+	if err != nil {
+		return "", fmt.Errorf("x.GetProfileURL: %w", err)
+	}
+	return "https://x.com/" + username, nil
+}
+
+// Posts on 𝕏 don't have titles
+func (t *X) GetTitle() (string, error) {
+	return "", nil
+}
+
+// Get the rating of the post from the fxtwitter API. 𝕏 only flags sensitive
+// media, without telling how explicit they are
+func (t *X) GetRating() (string, error) {
+	if t.apiContent == nil {
+		if err := t.scrapeAPI(); err != nil {
+			return "", fmt.Errorf("x.GetRating: %w", err)
+		}
+	}
+	if t.apiContent.Tweet.PossiblySensitive {
+		return RatingMature, nil
+	}
+	return RatingGeneral, nil
+}
@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"social-2-telego/database"
+	"social-2-telego/utils"
+)
+
+// Manage the local artist registry, with add, remove or list
+func (c *Commands) manageAliases(msg utils.IncomingMessage, args []string) string {
+	if len(args) == 0 {
+		return "Usage: " + c.commands["alias"].usage
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "add":
+		// the display name may be quoted, so the raw text is parsed instead
+		// of the fields
+		_, rest, _ := strings.Cut(msg.Text, args[0])
+		return c.addAlias(rest)
+	case "remove":
+		if len(args) != 2 {
+			return "Usage: " + c.commands["alias"].usage
+		}
+		deleted, err := c.db.DeleteLocalArtist(strings.TrimPrefix(args[1], "@"))
+		if err != nil {
+			slog.Error("failed to delete the artist", "err", err)
+			return "Failed to remove the artist"
+		}
+		if !deleted {
+			return "No artist called " + args[1]
+		}
+		return "Removed " + args[1]
+	case "list":
+		return c.listAliases()
+	default:
+		return "Usage: " + c.commands["alias"].usage
+	}
+}
+
+// Add the handles of an artist to the registry. The defaults which aren't
+// given are kept from the artist's previous record
+func (c *Commands) addAlias(text string) string {
+	spec, err := utils.ParseAlias(text)
+	if err != nil {
+		return fmt.Sprintf("%v\nUsage: %s", err, c.commands["alias"].usage)
+	}
+	if spec.Target != "" && c.appState.GetRouting().Target(spec.Target) == nil {
+		return fmt.Sprintf("Unknown target >%s", spec.Target)
+	}
+
+	artist, err := c.db.GetLocalArtist(spec.Username)
+	if err != nil {
+		slog.Error("failed to get the artist", "err", err)
+		return "Failed to save the artist"
+	}
+	if artist == nil {
+		artist = &database.Artist{}
+	}
+	artist.Username = spec.Username
+	artist.DisplayName = spec.DisplayName
+	artist.Aliases = spec.Aliases
+	if len(spec.Hashtags) > 0 {
+		artist.Hashtags = spec.Hashtags
+	}
+	if spec.Target != "" {
+		artist.Target = spec.Target
+	}
+	if spec.Spoiler != nil {
+		artist.Spoiler = *spec.Spoiler
+	}
+	if err := c.db.SaveLocalArtist(artist); err != nil {
+		slog.Error("failed to save the artist", "err", err)
+		return "Failed to save the artist"
+	}
+	return fmt.Sprintf("%s is now credited as %s", strings.Join(spec.Aliases, ", "), spec.DisplayName)
+}
+
+// List the artists of the registry with their handles
+func (c *Commands) listAliases() string {
+	artists, err := c.db.ListLocalArtists()
+	if err != nil {
+		slog.Error("failed to list the artists", "err", err)
+		return "Failed to list the artists"
+	}
+	if len(artists) == 0 {
+		return "No artists, add one with /alias add"
+	}
+
+	lines := []string{fmt.Sprintf("%d artist(s)", len(artists))}
+	for i, artist := range artists {
+		if i == maxListedItems {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(artists)-i))
+			break
+		}
+		line := fmt.Sprintf("%s => %s %q", strings.Join(artist.Aliases, " "), artist.Username, artist.DisplayName)
+		for _, hashtag := range artist.Hashtags {
+			line += " #" + hashtag
+		}
+		if artist.Target != "" {
+			line += " >" + artist.Target
+		}
+		if artist.Spoiler {
+			line += " +spoiler"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,319 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"social-2-telego/utils"
+)
+
+const (
+	// How many times a flood wait is waited out before giving up
+	maxFloodWaitRetries = 3
+	// Longer flood waits, and longer waits for the rate limits, are left to
+	// the job queue instead of blocking
+	maxFloodWait = 2 * time.Minute
+	// How long a Bot API call may take, uploads of up to 50MB included,
+	// before the worker gives up on it
+	requestTimeout = 5 * time.Minute
+)
+
+// A Bot API client shared by every worker, keeping under Telegram's limits
+// globally and per chat, and waiting out the flood waits it still gets
+type Client struct {
+	appState   *utils.AppState
+	httpClient *http.Client
+	apiURL     string
+	global     *utils.TokenBucket
+	// Waits out flood waits, replaced by the tests
+	sleep func(time.Duration)
+
+	mu    sync.Mutex
+	chats map[string]*utils.TokenBucket
+}
+
+// Create a new Client instance
+func NewClient(appState *utils.AppState) *Client {
+	return &Client{
+		appState:   appState,
+		httpClient: &http.Client{Timeout: requestTimeout},
+		apiURL:     "https://api.telegram.org",
+		global:     utils.NewTokenBucket(appState.GetGlobalRateLimit(), int(appState.GetGlobalRateLimit())),
+		chats:      make(map[string]*utils.TokenBucket),
+		sleep:      time.Sleep,
+	}
+}
+
+// Get the limiter of a chat. Only groups and channels are limited, private
+// chats only count towards the global limit
+func (c *Client) chatLimiter(chatID string) *utils.TokenBucket {
+	if !strings.HasPrefix(chatID, "-") && !strings.HasPrefix(chatID, "@") {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	limiter, ok := c.chats[chatID]
+	if !ok {
+		perMinute := c.appState.GetChatRateLimit()
+		limiter = utils.NewTokenBucket(float64(perMinute)/60, perMinute)
+		c.chats[chatID] = limiter
+	}
+	return limiter
+}
+
+// How many messages a request counts as, every item of an album counts
+func messageCount(request TelegramRequest) int {
+	if request.EndPoint != SendTypeMediaGroup {
+		return 1
+	}
+	var media []json.RawMessage
+	if err := json.Unmarshal([]byte(request.Data.Get("media")), &media); err != nil || len(media) == 0 {
+		return 1
+	}
+	return len(media)
+}
+
+// Send one request to the Bot API once the rate limits allow it, returning the
+// IDs of the messages it created. Short flood waits are waited out here
+func (c *Client) Send(request TelegramRequest) ([]int, error) {
+	count := messageCount(request)
+	for attempt := 0; ; attempt++ {
+		if err := c.waitForLimits(request.Data.Get("chat_id"), count); err != nil {
+			return nil, err
+		}
+
+		messageIDs, err := c.send(request)
+		retryAfter := floodWait(err)
+		if retryAfter == 0 || retryAfter > maxFloodWait || attempt == maxFloodWaitRetries {
+			return messageIDs, err
+		}
+
+		slog.Warn("flood wait", "endpoint", request.EndPoint, "retry_after", retryAfter)
+		c.sleep(retryAfter)
+	}
+}
+
+// Wait until the limits of the chat and the global one allow `count` more
+// messages. A wait longer than a flood wait worth blocking for fails with a
+// retryable error instead
+func (c *Client) waitForLimits(chatID string, count int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), maxFloodWait)
+	defer cancel()
+	if limiter := c.chatLimiter(chatID); limiter != nil {
+		if err := limiter.WaitN(ctx, count); err != nil {
+			return utils.Retryable(fmt.Errorf("rate limit of chat %s: %w", chatID, err))
+		}
+	}
+	if err := c.global.WaitN(ctx, count); err != nil {
+		return utils.Retryable(fmt.Errorf("global rate limit: %w", err))
+	}
+	return nil
+}
+
+// Get how long Telegram asked to wait before retrying, 0 if it didn't
+func floodWait(err error) time.Duration {
+	var retryableErr *utils.RetryableError
+	if !errors.As(err, &retryableErr) {
+		return 0
+	}
+	return retryableErr.RetryAfter
+}
+
+// Reply to an incoming message with a plain text, errors are only logged
+func (c *Client) Reply(msg utils.IncomingMessage, text string) {
+	if _, err := c.SendReply(msg, text); err != nil {
+		slog.Error("failed to reply", "err", err)
+	}
+}
+
+// Reply to a message with a plain text, returning the ID of the reply
+func (c *Client) SendReply(msg utils.IncomingMessage, text string) (int, error) {
+	data := url.Values{
+		"chat_id":             {strconv.Itoa(msg.Chat.ID)},
+		"text":                {text},
+		"reply_to_message_id": {strconv.Itoa(msg.MessageID)},
+	}
+	ids, err := c.Send(TelegramRequest{EndPoint: SendTypeMessage, Data: data})
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, fmt.Errorf("Client.SendReply: no message ID returned")
+	}
+	return ids[0], nil
+}
+
+// Answer the press of an inline keyboard button, the text is shown as a
+// notification. Errors are only logged
+func (c *Client) AnswerCallback(queryID string, text string) {
+	data := url.Values{
+		"callback_query_id": {queryID},
+		"text":              {text},
+	}
+	if _, err := c.Send(TelegramRequest{EndPoint: SendTypeAnswerCallbackQuery, Data: data}); err != nil {
+		slog.Error("failed to answer callback", "err", err)
+	}
+}
+
+// Replace the text of a message with a plain one, removing its inline
+// keyboard. Errors are only logged
+func (c *Client) EditText(chat string, messageID int, text string) {
+	chatID, _ := utils.SplitChat(chat)
+	data := url.Values{
+		"chat_id":    {chatID},
+		"message_id": {strconv.Itoa(messageID)},
+		"text":       {text},
+	}
+	if _, err := c.Send(TelegramRequest{EndPoint: SendTypeEditMessageText, Data: data}); err != nil {
+		slog.Error("failed to edit message", "err", err)
+	}
+}
+
+// Delete messages of a chat, IDs of 0 are skipped. Errors are only logged,
+// messages older than 48 hours can't be deleted
+func (c *Client) DeleteMessages(chat string, messageIDs []int) {
+	chatID, _ := utils.SplitChat(chat)
+	ids := make([]int, 0, len(messageIDs))
+	for _, id := range messageIDs {
+		if id != 0 {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	idsJSON, _ := json.Marshal(ids)
+	data := url.Values{
+		"chat_id":     {chatID},
+		"message_ids": {string(idsJSON)},
+	}
+	if _, err := c.Send(TelegramRequest{EndPoint: SendTypeDeleteMessages, Data: data}); err != nil {
+		slog.Error("failed to delete messages", "err", err)
+	}
+}
+
+// Send one request to the Bot API and check the response, returning the IDs
+// of the messages it created
+func (c *Client) send(request TelegramRequest) ([]int, error) {
+	// init the request, local files can only be uploaded as multipart
+	apiURL := c.apiURL + "/bot" + c.appState.GetBotToken() + "/" + string(request.EndPoint)
+	var resp *http.Response
+	var err error
+	switch len(request.Files) {
+	case 0:
+		resp, err = c.httpClient.PostForm(apiURL, request.Data)
+	default:
+		body, contentType := multipartBody(request)
+		resp, err = c.httpClient.Post(apiURL, contentType, body)
+		if err != nil {
+			// stop the writer, which would otherwise block with the file open
+			body.CloseWithError(err)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// read & check the response
+	var respBody struct {
+		OK          bool            `json:"ok"`
+		ErrorCode   int             `json:"error_code"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if err := json.Unmarshal(body, &respBody); err != nil {
+		// proxies in front of the Bot API answer 5xx with HTML
+		return nil, utils.HTTPStatusError(resp.StatusCode, fmt.Errorf("failed to unmarshal response: %w", err))
+	}
+	if !respBody.OK {
+		err := fmt.Errorf("error_code %d: %s", respBody.ErrorCode, respBody.Description)
+		if respBody.Parameters.RetryAfter > 0 {
+			return nil, &utils.RetryableError{
+				Err:        err,
+				RetryAfter: time.Duration(respBody.Parameters.RetryAfter) * time.Second,
+			}
+		}
+		return nil, utils.HTTPStatusError(respBody.ErrorCode, err)
+	}
+
+	// sendMediaGroup returns an array of messages, the others a single one
+	type sentMessage struct {
+		MessageID int `json:"message_id"`
+	}
+	var messages []sentMessage
+	if err := json.Unmarshal(respBody.Result, &messages); err != nil {
+		var message sentMessage
+		if err := json.Unmarshal(respBody.Result, &message); err != nil {
+			// methods like deleteMessages only return true
+			if string(respBody.Result) == "true" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("failed to unmarshal result: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	messageIDs := make([]int, 0, len(messages))
+	for _, message := range messages {
+		messageIDs = append(messageIDs, message.MessageID)
+	}
+	return messageIDs, nil
+}
+
+// Stream the fields and the files of a request as a multipart/form-data body.
+// The body must be read to the end or closed, its writer stops only then
+func multipartBody(request TelegramRequest) (*io.PipeReader, string) {
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+
+	go func() {
+		err := func() error {
+			for key, values := range request.Data {
+				for _, value := range values {
+					if err := form.WriteField(key, value); err != nil {
+						return err
+					}
+				}
+			}
+			for field, path := range request.Files {
+				file, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				part, err := form.CreateFormFile(field, filepath.Base(path))
+				if err == nil {
+					_, err = io.Copy(part, file)
+				}
+				file.Close()
+				if err != nil {
+					return err
+				}
+			}
+			return form.Close()
+		}()
+		writer.CloseWithError(err)
+	}()
+
+	return reader, form.FormDataContentType()
+}
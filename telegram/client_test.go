@@ -0,0 +1,151 @@
+package telegram_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"social-2-telego/telegram"
+	"social-2-telego/utils"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMultipartBody(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(path, []byte("video data"), 0o600); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	body, contentType := telegram.MultipartBody(telegram.TelegramRequest{
+		EndPoint: telegram.SendTypeVideo,
+		Data:     url.Values{"chat_id": {"-100123"}, "caption": {"hi"}},
+		Files:    map[string]string{"video": path},
+	})
+
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	form, err := multipart.NewReader(body, params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if form.Value["chat_id"][0] != "-100123" || form.Value["caption"][0] != "hi" {
+		t.Errorf("Unexpected fields %v", form.Value)
+	}
+	files := form.File["video"]
+	if len(files) != 1 || files[0].Filename != "video.mp4" {
+		t.Fatalf("Unexpected files %v", form.File)
+	}
+	file, _ := files[0].Open()
+	defer file.Close()
+	if data, _ := io.ReadAll(file); string(data) != "video data" {
+		t.Errorf("Unexpected file content %q", data)
+	}
+}
+
+func TestMultipartBodyFailsOnMissingFiles(t *testing.T) {
+	body, _ := telegram.MultipartBody(telegram.TelegramRequest{
+		EndPoint: telegram.SendTypeVideo,
+		Files:    map[string]string{"video": filepath.Join(t.TempDir(), "missing.mp4")},
+	})
+	if _, err := io.ReadAll(body); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the missing file to fail the body, got %v", err)
+	}
+}
+
+// A client of a Bot API answering the nth request with `respond(n)`, and the
+// flood waits it waited out
+func newFloodedClient(t *testing.T, respond func(n int) string) (*telegram.Client, *atomic.Int32, *[]time.Duration) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bottoken/sendMessage" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, respond(int(requests.Add(1))))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("BOT_TOKEN", "token")
+	t.Setenv("ARTIST_DB_DOMAIN", "https://artistdb.example.com/{username}")
+	sleeps := make([]time.Duration, 0)
+	client := telegram.NewTestClient(utils.NewAppState(), server.URL, func(d time.Duration) { sleeps = append(sleeps, d) })
+	return client, &requests, &sleeps
+}
+
+func floodWaitResponse(retryAfter int) string {
+	return fmt.Sprintf(`{"ok": false, "error_code": 429, "description": "Too Many Requests: retry after %d", "parameters": {"retry_after": %d}}`, retryAfter, retryAfter)
+}
+
+func sendTestMessage(client *telegram.Client) ([]int, error) {
+	return client.Send(telegram.TelegramRequest{EndPoint: telegram.SendTypeMessage, Data: url.Values{"chat_id": {"1"}, "text": {"hi"}}})
+}
+
+func TestSendWaitsOutFloodWaits(t *testing.T) {
+	client, requests, sleeps := newFloodedClient(t, func(n int) string {
+		if n == 1 {
+			return floodWaitResponse(3)
+		}
+		return `{"ok": true, "result": {"message_id": 7}}`
+	})
+
+	ids, err := sendTestMessage(client)
+	if err != nil || !slices.Equal(ids, []int{7}) {
+		t.Fatalf("Expected message 7 to be sent, got %v, %v", ids, err)
+	}
+	if requests.Load() != 2 || !slices.Equal(*sleeps, []time.Duration{3 * time.Second}) {
+		t.Errorf("Expected one retry after 3s, got %d requests and %v", requests.Load(), *sleeps)
+	}
+}
+
+func TestSendGivesUpOnRepeatedFloodWaits(t *testing.T) {
+	client, requests, sleeps := newFloodedClient(t, func(int) string { return floodWaitResponse(1) })
+
+	_, err := sendTestMessage(client)
+	if retryable, retryAfter := utils.IsRetryable(err); !retryable || retryAfter != time.Second {
+		t.Errorf("Expected a retryable error after 1s, got %v", err)
+	}
+	if n := int(requests.Load()); n != telegram.MaxFloodWaitRetries+1 || len(*sleeps) != telegram.MaxFloodWaitRetries {
+		t.Errorf("Expected %d retries, got %d requests and %v", telegram.MaxFloodWaitRetries, n, *sleeps)
+	}
+}
+
+func TestSendLeavesLongFloodWaitsToTheQueue(t *testing.T) {
+	client, requests, sleeps := newFloodedClient(t, func(int) string { return floodWaitResponse(300) })
+
+	_, err := sendTestMessage(client)
+	if retryable, retryAfter := utils.IsRetryable(err); !retryable || retryAfter != 300*time.Second {
+		t.Errorf("Expected a retryable error after 300s, got %v", err)
+	}
+	if requests.Load() != 1 || len(*sleeps) != 0 {
+		t.Errorf("Expected no retry, got %d requests and %v", requests.Load(), *sleeps)
+	}
+}
+
+func TestChatLimiterOnlyLimitsGroupsAndChannels(t *testing.T) {
+	t.Setenv("BOT_TOKEN", "token")
+	t.Setenv("ARTIST_DB_DOMAIN", "https://artistdb.example.com/{username}")
+	client := telegram.NewClient(utils.NewAppState())
+
+	if client.ChatLimiter("12345") != nil {
+		t.Error("Expected private chats to have no limiter")
+	}
+	for _, chat := range []string{"-100123", "@channel"} {
+		limiter := client.ChatLimiter(chat)
+		if limiter == nil || limiter != client.ChatLimiter(chat) {
+			t.Errorf("%s: expected one limiter shared by every call", chat)
+		}
+	}
+	if client.ChatLimiter("-100123") == client.ChatLimiter("@channel") {
+		t.Error("Expected each chat to have its own limiter")
+	}
+}
@@ -0,0 +1,227 @@
+package telegram
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"social-2-telego/database"
+	"social-2-telego/utils"
+)
+
+// Telegram rejects messages longer than 4096 characters, lists are cut well
+// before that
+const maxListedItems = 20
+
+// A command sent to the bot, e.g. "/replay 12". It returns the text to reply
+type command struct {
+	usage       string
+	description string
+	handle      func(msg utils.IncomingMessage, args []string) string
+	// Whether only moderators may run it
+	moderator bool
+}
+
+// Handle the messages starting with "/" instead of queueing them as links
+type Commands struct {
+	appState *utils.AppState
+	db       *database.Database
+	client   *Client
+	commands map[string]command
+}
+
+// Create a new Commands instance
+func NewCommands(appState *utils.AppState, db *database.Database, client *Client) *Commands {
+	c := &Commands{
+		appState: appState,
+		db:       db,
+		client:   client,
+	}
+	c.commands = map[string]command{
+		"help": {
+			usage:       "/help",
+			description: "List the commands",
+			handle:      c.help,
+		},
+		"dead": {
+			usage:       "/dead",
+			description: "List the jobs which ran out of retries",
+			handle:      c.listDeadJobs,
+		},
+		"replay": {
+			usage:       "/replay <id>... | all",
+			description: "Queue dead or failed jobs again",
+			handle:      c.replayJobs,
+			moderator:   true,
+		},
+		"scheduled": {
+			usage:       "/scheduled",
+			description: "List the pending scheduled posts",
+			handle:      c.listScheduledJobs,
+		},
+		"move": {
+			usage:       "/move <id> <at 2026-10-20 18:00 | in 3h>",
+			description: "Move a scheduled post to another time",
+			handle:      c.moveScheduledJob,
+			moderator:   true,
+		},
+		"cancel": {
+			usage:       "/cancel <id>",
+			description: "Cancel a scheduled post",
+			handle:      c.cancelScheduledJob,
+			moderator:   true,
+		},
+		"queue": {
+			usage:       "/queue",
+			description: "List the posts waiting for a slot",
+			handle:      c.listQueue,
+		},
+		"skip": {
+			usage:       "/skip [id]",
+			description: "Take a post out of the queue, the next one by default",
+			handle:      c.skipQueuedJob,
+			moderator:   true,
+		},
+		"bump": {
+			usage:       "/bump <id>",
+			description: "Publish a queued post in the next slot",
+			handle:      c.bumpQueuedJob,
+			moderator:   true,
+		},
+		"clear": {
+			usage:       "/clear",
+			description: "Take every post out of the queue",
+			handle:      c.clearQueue,
+			moderator:   true,
+		},
+		"alias": {
+			usage:       `/alias add <site:handle>... => <username> ["Display Name"] [#hashtag]... [>target] [+spoiler] | remove <username> | list`,
+			description: "Manage how artists are credited when ArtistDB doesn't know them",
+			handle:      c.manageAliases,
+			moderator:   true,
+		},
+		"unauthorized": {
+			usage:       "/unauthorized",
+			description: "List the latest messages of users who aren't allowed",
+			handle:      c.listUnauthorizedAttempts,
+			moderator:   true,
+		},
+	}
+	return c
+}
+
+// Run the command of a message and reply with its result. Only the messages
+// of authorized users get here, the listener turns the others away
+func (c *Commands) Handle(msg utils.IncomingMessage) {
+	// commands can be addressed to a bot in groups, e.g. "/help@some_bot"
+	fields := strings.Fields(msg.Text)
+	name := strings.ToLower(strings.SplitN(strings.TrimPrefix(fields[0], "/"), "@", 2)[0])
+	cmd, ok := c.commands[name]
+	if !ok {
+		c.client.Reply(msg, "Unknown command, see /help")
+		return
+	}
+	if cmd.moderator && c.appState.GetRole(msg.From.Username) != utils.RoleModerator {
+		c.client.Reply(msg, "Only moderators can use /"+name)
+		return
+	}
+	c.client.Reply(msg, cmd.handle(msg, fields[1:]))
+}
+
+// List the commands with their usage
+func (c *Commands) help(_ utils.IncomingMessage, _ []string) string {
+	names := make([]string, 0, len(c.commands))
+	for name := range c.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s - %s", c.commands[name].usage, c.commands[name].description))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// List the jobs in the dead-letter list
+func (c *Commands) listDeadJobs(_ utils.IncomingMessage, _ []string) string {
+	jobs, err := c.db.ListJobs(database.JobStateDead)
+	if err != nil {
+		slog.Error("failed to list dead jobs", "err", err)
+		return "Failed to list the dead jobs"
+	}
+	if len(jobs) == 0 {
+		return "No dead jobs"
+	}
+
+	lines := []string{fmt.Sprintf("%d dead job(s), replay with /replay <id> or /replay all", len(jobs))}
+	for i, job := range jobs {
+		if i == maxListedItems {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(jobs)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("#%d %s\n  %d attempt(s), %s", job.ID, job.Message.Text, job.Attempts, job.Error))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// List the latest attempts of the audit log
+func (c *Commands) listUnauthorizedAttempts(_ utils.IncomingMessage, _ []string) string {
+	attempts, err := c.db.ListUnauthorizedAttempts(maxListedItems)
+	if err != nil {
+		slog.Error("failed to list unauthorized attempts", "err", err)
+		return "Failed to list the unauthorized attempts"
+	}
+	if len(attempts) == 0 {
+		return "No unauthorized attempts"
+	}
+
+	lines := []string{fmt.Sprintf("Latest %d unauthorized attempt(s)", len(attempts))}
+	for _, attempt := range attempts {
+		who := fmt.Sprintf("user %d", attempt.UserID)
+		if attempt.Username != "" {
+			who = "@" + attempt.Username
+		}
+		lines = append(lines, fmt.Sprintf("%s by %s\n  %s", attempt.At.Format(utils.ScheduleFormat), who, attempt.Text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Queue dead jobs again, either by ID or all of them
+func (c *Commands) replayJobs(_ utils.IncomingMessage, args []string) string {
+	if len(args) == 0 {
+		return "Usage: " + c.commands["replay"].usage
+	}
+
+	ids := make([]uint64, 0)
+	if len(args) == 1 && strings.ToLower(args[0]) == "all" {
+		jobs, err := c.db.ListJobs(database.JobStateDead)
+		if err != nil {
+			slog.Error("failed to list dead jobs", "err", err)
+			return "Failed to list the dead jobs"
+		}
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+	}
+	for _, arg := range args {
+		if id, err := parseJobID(arg); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return "Nothing to replay"
+	}
+
+	lines := make([]string, 0)
+	replayed := 0
+	for _, id := range ids {
+		if err := c.db.ReplayJob(id); err != nil {
+			lines = append(lines, err.Error())
+			continue
+		}
+		replayed++
+	}
+	return strings.Join(append([]string{fmt.Sprintf("Replayed %d job(s)", replayed)}, lines...), "\n")
+}
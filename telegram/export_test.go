@@ -0,0 +1,43 @@
+package telegram
+
+import (
+	"time"
+
+	"social-2-telego/utils"
+)
+
+// Unexported helpers used by the tests of package telegram_test
+var MultipartBody = multipartBody
+
+const (
+	MaxFloodWaitRetries = maxFloodWaitRetries
+	MaxRetryDelay       = maxRetryDelay
+)
+
+var RetryDelay = retryDelay
+
+// Get the limiter of a chat, nil for private chats
+func (c *Client) ChatLimiter(chatID string) *utils.TokenBucket { return c.chatLimiter(chatID) }
+
+// Lock the draft of a job, returns the function unlocking it
+func (l *draftLocks) Lock(jobID uint64) func() { return l.lock(jobID) }
+
+// How many drafts are locked or waited for
+func (l *draftLocks) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.locks)
+}
+
+var NewDraftLocks = newDraftLocks
+
+var PublishedText = publishedText
+
+// Create a client calling the Bot API at `apiURL`, which waits out flood waits
+// with `sleep`
+func NewTestClient(appState *utils.AppState, apiURL string, sleep func(time.Duration)) *Client {
+	client := NewClient(appState)
+	client.apiURL = apiURL
+	client.sleep = sleep
+	return client
+}
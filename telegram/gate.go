@@ -0,0 +1,97 @@
+package telegram
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"social-2-telego/database"
+	"social-2-telego/utils"
+)
+
+// Turn away the messages of users who aren't allowed to use the bot, before
+// anything is queued for them
+type Gate struct {
+	appState *utils.AppState
+	db       *database.Database
+	client   *Client
+	mu       sync.Mutex
+	// When each user was last replied to, so spamming the bot doesn't make
+	// it spam back
+	lastReply map[int]time.Time
+}
+
+// Create a new Gate instance
+func NewGate(appState *utils.AppState, db *database.Database, client *Client) *Gate {
+	return &Gate{
+		appState:  appState,
+		db:        db,
+		client:    client,
+		lastReply: make(map[int]time.Time),
+	}
+}
+
+// Reject the message of an unauthorized user, telling them at most once per
+// UNAUTHORIZED_REPLY_INTERVAL when a reply is set, and keep it in the audit
+// log
+func (g *Gate) Reject(msg utils.IncomingMessage) {
+	slog.Warn("unauthorized user", "username", msg.From.Username, "id", msg.From.ID, "text", msg.Text)
+
+	replied := g.shouldReply(msg.From.ID, time.Now())
+	if replied {
+		g.client.Reply(msg, g.appState.GetUnauthorizedReply())
+	}
+	if err := g.db.LogUnauthorizedAttempt(&database.UnauthorizedAttempt{
+		UserID:   msg.From.ID,
+		Username: msg.From.Username,
+		ChatID:   msg.Chat.ID,
+		Text:     msg.Text,
+		At:       time.Now(),
+		Replied:  replied,
+	}); err != nil {
+		slog.Error("failed to audit an unauthorized attempt", "err", err)
+	}
+}
+
+// Turn away the press of a button by an unauthorized user, the press is
+// answered without doing anything and kept in the audit log
+func (g *Gate) RejectCallback(query utils.CallbackQuery) {
+	slog.Warn("unauthorized button press", "username", query.From.Username, "id", query.From.ID, "data", query.Data)
+
+	g.client.AnswerCallback(query.ID, "You're not allowed to use this bot")
+	chatID := 0
+	if query.Message != nil {
+		chatID = query.Message.Chat.ID
+	}
+	if err := g.db.LogUnauthorizedAttempt(&database.UnauthorizedAttempt{
+		UserID:   query.From.ID,
+		Username: query.From.Username,
+		ChatID:   chatID,
+		Text:     "button " + query.Data,
+		At:       time.Now(),
+		Replied:  true,
+	}); err != nil {
+		slog.Error("failed to audit an unauthorized attempt", "err", err)
+	}
+}
+
+// Whether a user gets a reply now, remembering it when they do
+func (g *Gate) shouldReply(userID int, now time.Time) bool {
+	if g.appState.GetUnauthorizedReply() == "" {
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if last, ok := g.lastReply[userID]; ok && now.Sub(last) < g.appState.GetUnauthorizedReplyInterval() {
+		return false
+	}
+	// forget the users who can be replied to again, the map stays small
+	for id, last := range g.lastReply {
+		if now.Sub(last) >= g.appState.GetUnauthorizedReplyInterval() {
+			delete(g.lastReply, id)
+		}
+	}
+	g.lastReply[userID] = now
+	return true
+}
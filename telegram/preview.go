@@ -0,0 +1,506 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"social-2-telego/database"
+	"social-2-telego/media_processor"
+	"social-2-telego/social"
+	"social-2-telego/utils"
+)
+
+// How many "Drop N" buttons fit in one row of the preview keyboard
+const dropButtonsPerRow = 5
+
+// One button of an inline keyboard
+type inlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+// Sends drafts to their requester as a preview with buttons to publish, edit
+// or cancel them, and handles those buttons
+type Previews struct {
+	appState  *utils.AppState
+	db        *database.Database
+	client    *Client
+	processor *media_processor.Processor
+	// The presses and replies on one draft are applied one after another,
+	// so an edit isn't lost to another one or to publishing
+	locks *draftLocks
+}
+
+// Create a new Previews instance
+func NewPreviews(appState *utils.AppState, db *database.Database, client *Client) *Previews {
+	return &Previews{
+		appState:  appState,
+		db:        db,
+		client:    client,
+		processor: media_processor.NewProcessor(appState),
+		locks:     newDraftLocks(),
+	}
+}
+
+// A mutex per draft, dropped once nobody holds or waits for it
+type draftLocks struct {
+	mu    sync.Mutex
+	locks map[uint64]*draftLock
+}
+
+// The mutex of one draft
+type draftLock struct {
+	mu sync.Mutex
+	// How many goroutines hold or wait for the lock
+	users int
+}
+
+// Create an empty set of draft locks
+func newDraftLocks() *draftLocks {
+	return &draftLocks{locks: make(map[uint64]*draftLock)}
+}
+
+// Lock the draft of a job, returns the function unlocking it
+func (l *draftLocks) lock(jobID uint64) func() {
+	l.mu.Lock()
+	lock, ok := l.locks[jobID]
+	if !ok {
+		lock = &draftLock{}
+		l.locks[jobID] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.users--
+		if lock.users == 0 {
+			delete(l.locks, jobID)
+		}
+	}
+}
+
+// Build the message of a draft with its processed media, laid out like its
+// target wants
+func draftMessage(appState *utils.AppState, draft *database.Draft, media []social.ScrapedMedia) *TelegramMessage {
+	var caption *utils.CaptionTemplate
+	if target := appState.GetRouting().Target(draft.TargetName); target != nil {
+		caption = target.GetCaptionTemplate()
+	}
+
+	teleMsg := &TelegramMessage{}
+	return teleMsg.
+		SetCaptionTemplate(caption).
+		SetArtistDB(appState.GetArtistDBDomain()).
+		SetContent(func(string) string { return draft.Content }).
+		SetArtistNameAndUsername(draft.AuthorInfo).
+		SetHashtags(draft.Hashtags).
+		SetMedia(media).
+		SetPostURL(draft.PostURL).
+		SetPostInfo(draft.Title, draft.Source, draft.Rating).
+		SetSpoiler(draft.Spoiler)
+}
+
+// Send a draft to its requester as it would be published, followed by the
+// buttons to review it. The previous preview of the draft is deleted
+func (p *Previews) Send(draft *database.Draft) error {
+	text := fmt.Sprintf("Preview of #%d for %s", draft.JobID, draft.TargetChat)
+	previewIDs, controlID, err := p.render(draft, draft.PreviewChat, append(draft.PreviewMessageIDs, draft.ControlMessageID), text, previewKeyboard(draft))
+	if err != nil {
+		return err
+	}
+
+	draft.PreviewMessageIDs = previewIDs
+	draft.ControlMessageID = controlID
+	return p.db.SaveDraft(draft)
+}
+
+// Send a draft to the review chat with the buttons for moderators to approve or
+// reject it
+func (p *Previews) SendReview(draft *database.Draft, submitter string) error {
+	draft.ReviewChat = p.appState.GetReviewChat()
+	text := fmt.Sprintf("#%d from @%s for %s", draft.JobID, submitter, draft.TargetChat)
+	keyboard := [][]inlineKeyboardButton{{
+		{Text: "Approve", CallbackData: callbackData("approve", draft.JobID, 0)},
+		{Text: "Reject", CallbackData: callbackData("reject", draft.JobID, 0)},
+	}}
+	reviewIDs, controlID, err := p.render(draft, draft.ReviewChat, append(draft.ReviewMessageIDs, draft.ReviewControlMessageID), text, keyboard)
+	if err != nil {
+		return err
+	}
+
+	draft.ReviewMessageIDs = reviewIDs
+	draft.ReviewControlMessageID = controlID
+	return p.db.SaveDraft(draft)
+}
+
+// Send a draft to a chat as it would be published, followed by a message with
+// buttons, after deleting the messages of the previous rendering. Returns the
+// IDs of the post's messages and of the buttons' message
+func (p *Previews) render(draft *database.Draft, chat string, previous []int, text string, keyboard [][]inlineKeyboardButton) ([]int, int, error) {
+	p.client.DeleteMessages(chat, previous)
+
+	// photos are only hashed when published, previews are rendered again
+	// on every edit
+	media, _, cleanup, err := p.processor.Process(draft.KeptMedia(), false)
+	if err != nil {
+		slog.Warn("failed to process media, sending them as is", "err", err)
+	}
+	defer cleanup()
+
+	requests, err := draftMessage(p.appState, draft, media).ToData(chat)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to compose preview: %w", err)
+	}
+	messageIDs := make([]int, 0)
+	for _, request := range requests {
+		ids, err := p.client.Send(request)
+		if err != nil {
+			return nil, 0, fmt.Errorf("preview not sent to %s: %w", request.EndPoint, err)
+		}
+		messageIDs = append(messageIDs, ids...)
+	}
+
+	// albums can't carry buttons, they go in a message of their own
+	markup, err := json.Marshal(map[string]any{"inline_keyboard": keyboard})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to marshal keyboard: %w", err)
+	}
+	data := chatData(chat)
+	data.Add("text", text)
+	data.Add("reply_markup", string(markup))
+	if len(messageIDs) > 0 {
+		data.Add("reply_to_message_id", strconv.Itoa(messageIDs[0]))
+	}
+	controlIDs, err := p.client.Send(TelegramRequest{EndPoint: SendTypeMessage, Data: data})
+	if err != nil {
+		return nil, 0, fmt.Errorf("preview buttons not sent: %w", err)
+	}
+	return messageIDs, controlIDs[0], nil
+}
+
+// The buttons under a preview, labelled after the current state of the draft
+func previewKeyboard(draft *database.Draft) [][]inlineKeyboardButton {
+	spoiler := "Spoiler: off"
+	if draft.Spoiler {
+		spoiler = "Spoiler: on"
+	}
+	rows := [][]inlineKeyboardButton{
+		{
+			{Text: "Publish", CallbackData: callbackData("publish", draft.JobID, 0)},
+			{Text: "Cancel", CallbackData: callbackData("cancel", draft.JobID, 0)},
+		},
+		{
+			{Text: "Edit caption", CallbackData: callbackData("caption", draft.JobID, 0)},
+			{Text: spoiler, CallbackData: callbackData("spoiler", draft.JobID, 0)},
+		},
+	}
+
+	row := make([]inlineKeyboardButton, 0)
+	for i := range draft.Media {
+		label := fmt.Sprintf("Drop %d", i+1)
+		if draft.Dropped[i] {
+			label = fmt.Sprintf("Keep %d", i+1)
+		}
+		row = append(row, inlineKeyboardButton{Text: label, CallbackData: callbackData("drop", draft.JobID, i)})
+		if len(row) == dropButtonsPerRow || i == len(draft.Media)-1 {
+			rows = append(rows, row)
+			row = make([]inlineKeyboardButton, 0)
+		}
+	}
+	return rows
+}
+
+// Encode the action of a button, e.g. "drop:12:3" for the 4th media of job 12
+func callbackData(action string, jobID uint64, index int) string {
+	return fmt.Sprintf("%s:%d:%d", action, jobID, index)
+}
+
+// Decode the action of a button
+func parseCallbackData(data string) (string, uint64, int, error) {
+	parts := strings.Split(data, ":")
+	if len(parts) != 3 {
+		return "", 0, 0, fmt.Errorf("invalid callback data %q", data)
+	}
+	jobID, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid callback data %q", data)
+	}
+	index, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid callback data %q", data)
+	}
+	return parts[0], jobID, index, nil
+}
+
+// Handle the press of a preview button. The press is answered right away,
+// updating the preview comes after since processing media takes a while. A
+// press waits for the previous ones on the same draft to be fully applied
+func (p *Previews) HandleCallback(query utils.CallbackQuery) {
+	if _, jobID, _, err := parseCallbackData(query.Data); err == nil {
+		defer p.locks.lock(jobID)()
+	}
+
+	answer, update := p.handleCallback(query)
+	p.client.AnswerCallback(query.ID, answer)
+	if update == nil {
+		return
+	}
+	if err := update(); err != nil {
+		slog.Error("failed to update preview", "err", err)
+	}
+}
+
+// Apply a button to its draft, returning the answer to show and what's left
+// to do after answering, if anything
+func (p *Previews) handleCallback(query utils.CallbackQuery) (string, func() error) {
+	action, jobID, index, err := parseCallbackData(query.Data)
+	if err != nil {
+		slog.Warn("unknown callback", "err", err)
+		return "Unknown button", nil
+	}
+
+	switch action {
+	case "approve", "reject":
+		return p.handleReviewCallback(action, jobID, query.From)
+	}
+
+	draft, job, answer := p.reviewable(jobID, query.From.ID)
+	if answer != "" {
+		return answer, nil
+	}
+
+	switch action {
+	case "publish":
+		if err := p.db.ApproveJob(job.ID); err != nil {
+			return err.Error(), nil
+		}
+		if p.appState.NeedsReview(job.Message.From.Username) {
+			p.client.EditText(draft.PreviewChat, draft.ControlMessageID, fmt.Sprintf("Submitted #%d for review", job.ID))
+			return "Submitted for review", nil
+		}
+		p.client.EditText(draft.PreviewChat, draft.ControlMessageID, fmt.Sprintf("Publishing #%d to %s", job.ID, draft.TargetChat))
+		return "Publishing", nil
+	case "cancel":
+		if err := p.db.CancelJob(job.ID); err != nil {
+			return err.Error(), nil
+		}
+		if err := p.db.DeleteDraft(job.ID); err != nil {
+			slog.Warn("failed to delete draft", "err", err)
+		}
+		p.client.EditText(draft.PreviewChat, draft.ControlMessageID, fmt.Sprintf("Cancelled #%d", job.ID))
+		return "Cancelled", nil
+	case "caption":
+		return "Reply with the new caption", func() error { return p.promptCaption(draft) }
+	case "spoiler":
+		draft.Spoiler = !draft.Spoiler
+		return "Updating the preview", func() error { return p.Send(draft) }
+	case "drop":
+		if index < 0 || index >= len(draft.Media) {
+			return "No such media", nil
+		}
+		if draft.Dropped == nil {
+			draft.Dropped = make(map[int]bool)
+		}
+		draft.Dropped[index] = !draft.Dropped[index]
+		return "Updating the preview", func() error { return p.Send(draft) }
+	default:
+		return "Unknown button", nil
+	}
+}
+
+// Get the draft and the job of a preview if the user may still review it,
+// otherwise the reason why not
+func (p *Previews) reviewable(jobID uint64, userID int) (*database.Draft, *database.Job, string) {
+	draft, err := p.db.GetDraft(jobID)
+	if err != nil {
+		slog.Error("failed to get draft", "err", err)
+		return nil, nil, "Failed to load the post"
+	}
+	job, err := p.db.GetJob(jobID)
+	if err != nil {
+		slog.Error("failed to get job", "err", err)
+		return nil, nil, "Failed to load the post"
+	}
+	switch {
+	case draft == nil || job == nil:
+		return nil, nil, "This post is gone"
+	case userID != draft.RequesterID:
+		return nil, nil, "Only the sender of the post can review it"
+	case job.State != database.JobStatePreview:
+		return nil, nil, fmt.Sprintf("This post is already %s", job.State)
+	}
+	return draft, job, ""
+}
+
+// Apply the button of a moderator in the review chat
+func (p *Previews) handleReviewCallback(action string, jobID uint64, moderator utils.User) (string, func() error) {
+	draft, job, answer := p.moderatable(jobID, moderator.Username)
+	if answer != "" {
+		return answer, nil
+	}
+
+	switch action {
+	case "approve":
+		if err := p.db.ReviewJob(job.ID, true, ""); err != nil {
+			return err.Error(), nil
+		}
+		decision := fmt.Sprintf("#%d was approved by @%s", job.ID, moderator.Username)
+		p.client.EditText(draft.ReviewChat, draft.ReviewControlMessageID, decision)
+		p.client.Reply(job.Message, decision)
+		return "Approved", nil
+	default:
+		return "Reply with the reason", func() error { return p.promptRejection(draft) }
+	}
+}
+
+// Get the draft and the job of a post under review if the user is a moderator
+// and it's still under review, otherwise the reason why not
+func (p *Previews) moderatable(jobID uint64, username string) (*database.Draft, *database.Job, string) {
+	if p.appState.GetRole(username) != utils.RoleModerator {
+		return nil, nil, "Only moderators can review posts"
+	}
+	draft, err := p.db.GetDraft(jobID)
+	if err != nil {
+		slog.Error("failed to get draft", "err", err)
+		return nil, nil, "Failed to load the post"
+	}
+	job, err := p.db.GetJob(jobID)
+	if err != nil {
+		slog.Error("failed to get job", "err", err)
+		return nil, nil, "Failed to load the post"
+	}
+	switch {
+	case draft == nil || job == nil:
+		return nil, nil, "This post is gone"
+	case job.State != database.JobStateReview:
+		return nil, nil, fmt.Sprintf("This post is already %s", job.State)
+	}
+	return draft, job, ""
+}
+
+// Ask the moderators why a post is rejected, the reply to the prompt rejects it
+func (p *Previews) promptRejection(draft *database.Draft) error {
+	id, err := p.prompt(draft.ReviewChat, draft.ReviewControlMessageID,
+		fmt.Sprintf("Reply to this message with the reason for rejecting #%d, or with - to give none", draft.JobID),
+		"Reason, or - for none")
+	if err != nil {
+		return err
+	}
+
+	draft.ReviewPromptMessageID = id
+	return p.db.SaveDraft(draft)
+}
+
+// Send a message asking for a reply, returning its ID
+func (p *Previews) prompt(chat string, replyTo int, text string, placeholder string) (int, error) {
+	markup, err := json.Marshal(map[string]any{
+		"force_reply":             true,
+		"selective":               true,
+		"input_field_placeholder": placeholder,
+	})
+	if err != nil {
+		return 0, err
+	}
+	data := chatData(chat)
+	data.Add("text", text)
+	data.Add("reply_to_message_id", strconv.Itoa(replyTo))
+	data.Add("reply_markup", string(markup))
+	ids, err := p.client.Send(TelegramRequest{EndPoint: SendTypeMessage, Data: data})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// Ask the requester for a new caption, their reply to the prompt replaces the
+// text of the post
+func (p *Previews) promptCaption(draft *database.Draft) error {
+	id, err := p.prompt(draft.PreviewChat, draft.ControlMessageID,
+		fmt.Sprintf("Reply to this message with the new caption of #%d, or with - to remove it", draft.JobID),
+		"New caption, or - to remove it")
+	if err != nil {
+		return err
+	}
+
+	draft.PromptMessageID = id
+	return p.db.SaveDraft(draft)
+}
+
+// Take a reply to a caption prompt as the new caption of its draft, or a reply
+// to a rejection prompt as the reason of the rejection. Returns false when the
+// message doesn't reply to a prompt
+func (p *Previews) HandleReply(msg utils.IncomingMessage) bool {
+	chat := strconv.Itoa(msg.Chat.ID)
+	draft, err := p.db.FindDraftByPrompt(chat, msg.ReplyToMessage.MessageID)
+	if err != nil {
+		slog.Error("failed to look up the prompt", "err", err)
+		return false
+	}
+	if draft == nil {
+		return false
+	}
+
+	if draft.IsReviewPrompt(chat, msg.ReplyToMessage.MessageID) {
+		go p.reject(draft.JobID, msg)
+		return true
+	}
+	go func() {
+		defer p.locks.lock(draft.JobID)()
+
+		draft, _, answer := p.reviewable(draft.JobID, msg.From.ID)
+		if answer != "" {
+			p.client.Reply(msg, answer)
+			return
+		}
+
+		draft.Content = ""
+		if text := strings.TrimSpace(msg.Text); text != "-" {
+			draft.Content = utils.EscapeSpecialChars(strings.ReplaceAll(text, `\`, `\\`), `\`)
+		}
+		draft.PromptMessageID = 0
+		if err := p.Send(draft); err != nil {
+			slog.Error("failed to update preview", "err", err)
+			p.client.Reply(msg, "Failed to update the preview")
+		}
+	}()
+	return true
+}
+
+// Reject a post under review with the reason given in a reply, and tell the
+// submitter why
+func (p *Previews) reject(jobID uint64, msg utils.IncomingMessage) {
+	defer p.locks.lock(jobID)()
+
+	draft, job, answer := p.moderatable(jobID, msg.From.Username)
+	if answer != "" {
+		p.client.Reply(msg, answer)
+		return
+	}
+
+	reason := strings.TrimSpace(msg.Text)
+	if reason == "-" {
+		reason = ""
+	}
+	if err := p.db.ReviewJob(job.ID, false, reason); err != nil {
+		p.client.Reply(msg, err.Error())
+		return
+	}
+
+	decision := fmt.Sprintf("#%d was rejected by @%s", job.ID, msg.From.Username)
+	if reason != "" {
+		decision += ": " + reason
+	}
+	p.client.EditText(draft.ReviewChat, draft.ReviewControlMessageID, decision)
+	p.client.Reply(job.Message, decision)
+	if err := p.db.DeleteDraft(job.ID); err != nil {
+		slog.Warn("failed to delete draft", "err", err)
+	}
+}
@@ -0,0 +1,35 @@
+package telegram_test
+
+import (
+	"social-2-telego/telegram"
+	"testing"
+	"time"
+)
+
+func TestDraftLocksSerializeOneDraft(t *testing.T) {
+	locks := telegram.NewDraftLocks()
+
+	unlock := locks.Lock(1)
+	acquired := make(chan func())
+	go func() { acquired <- locks.Lock(1) }()
+
+	// another draft isn't held back
+	locks.Lock(2)()
+
+	select {
+	case <-acquired:
+		t.Fatal("Expected the second lock of the same draft to wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case unlock = <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the second lock to be acquired once the first is released")
+	}
+	unlock()
+
+	if n := locks.Len(); n != 0 {
+		t.Errorf("Expected the locks to be dropped, %d left", n)
+	}
+}
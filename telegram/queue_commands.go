@@ -0,0 +1,98 @@
+package telegram
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"social-2-telego/utils"
+)
+
+// List the posts waiting in the posting queues with their estimated slot
+func (c *Commands) listQueue(_ utils.IncomingMessage, _ []string) string {
+	jobs, err := c.db.ListWaitingJobs()
+	if err != nil {
+		slog.Error("failed to list waiting jobs", "err", err)
+		return "Failed to list the queue"
+	}
+	if len(jobs) == 0 {
+		return "The queue is empty"
+	}
+
+	lines := []string{fmt.Sprintf("%d queued post(s)", len(jobs))}
+	positions := make(map[string]int)
+	for i, job := range jobs {
+		if i == maxListedItems {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(jobs)-i))
+			break
+		}
+		positions[job.Target]++
+
+		when := "next"
+		if schedule := c.appState.GetQueueSchedule(job.Target); schedule != nil {
+			last, err := c.db.LastSlot(job.Target)
+			if err != nil {
+				slog.Warn("failed to get the last slot", "err", err)
+			}
+			when = estimateSlot(schedule, last, time.Now(), positions[job.Target]).Format(utils.ScheduleFormat)
+		}
+		lines = append(lines, fmt.Sprintf("#%d %s to %s\n  %s", job.ID, when, job.Target, job.Message.Text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Take a post out of the queue without publishing it, the next one by default
+func (c *Commands) skipQueuedJob(_ utils.IncomingMessage, args []string) string {
+	var id uint64
+	switch len(args) {
+	case 0:
+		jobs, err := c.db.ListWaitingJobs()
+		if err != nil {
+			slog.Error("failed to list waiting jobs", "err", err)
+			return "Failed to list the queue"
+		}
+		if len(jobs) == 0 {
+			return "The queue is empty"
+		}
+		id = jobs[0].ID
+	case 1:
+		var err error
+		if id, err = parseJobID(args[0]); err != nil {
+			return err.Error()
+		}
+	default:
+		return "Usage: " + c.commands["skip"].usage
+	}
+
+	if err := c.db.CancelJob(id); err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("Skipped #%d", id)
+}
+
+// Move a post to the front of its queue, so it's published in the next slot
+func (c *Commands) bumpQueuedJob(_ utils.IncomingMessage, args []string) string {
+	if len(args) != 1 {
+		return "Usage: " + c.commands["bump"].usage
+	}
+	id, err := parseJobID(args[0])
+	if err != nil {
+		return err.Error()
+	}
+
+	if err := c.db.BumpJob(id); err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("Bumped #%d to the front of the queue", id)
+}
+
+// Take every post out of the queues without publishing them
+func (c *Commands) clearQueue(_ utils.IncomingMessage, _ []string) string {
+	cleared, err := c.db.ClearQueue("")
+	if err != nil {
+		slog.Error("failed to clear the queue", "err", err)
+		return "Failed to clear the queue"
+	}
+	return fmt.Sprintf("Cleared %d queued post(s)", cleared)
+}
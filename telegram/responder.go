@@ -1,172 +1,645 @@
 package telegram
 
 import (
-	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"log"
 	"log/slog"
-	"net/http"
+	"math/rand"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"social-2-telego/artistdb"
+	"social-2-telego/database"
+	"social-2-telego/input"
+	"social-2-telego/media_processor"
 	"social-2-telego/social"
 	"social-2-telego/utils"
 )
 
-// Continuously watching new messages from MessageQueue and respond to them
-func Responder(appState *utils.AppState) {
+// Returned by a job which moved itself to another state to be resumed later
+var errJobDeferred = errors.New("job deferred")
+
+const (
+	// How often workers look for jobs when they aren't woken up
+	jobPollInterval = 5 * time.Second
+	// The longest wait between two attempts of a job
+	maxRetryDelay = time.Hour
+	// How long a job stays in the queue before checking again whether the
+	// lines before it are published
+	turnRetryDelay = 2 * time.Second
+)
+
+type responder struct {
+	appState  *utils.AppState
+	db        *database.Database
+	client    *Client
+	processor *media_processor.Processor
+	previews  *Previews
+	artists   *artistdb.Client
+	status    *statusReporter
+}
+
+// Continuously take jobs from the queue and respond to them
+func Responder(appState *utils.AppState, db *database.Database, client *Client) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 
+	r := &responder{
+		appState:  appState,
+		db:        db,
+		client:    client,
+		processor: media_processor.NewProcessor(appState),
+		previews:  NewPreviews(appState, db, client),
+		artists:   artistdb.NewClient(appState, db),
+		status:    newStatusReporter(db, client),
+	}
+
+	// pick up what was interrupted by the last shutdown
+	resumed, err := db.ResumeJobs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if resumed > 0 {
+		slog.Info("resumed interrupted jobs", "count", resumed)
+	}
+
 	// create a number of for loops, each inside a goroutine
 	for i := 0; i < appState.GetNumWorkers(); i++ {
-		go func() {
-			for msg := range appState.MsgQueue {
-				slog.Debug("received message", "from", msg.From.Username, "text", msg.Text)
+		go r.work()
+	}
 
-				if !appState.IsAuthorized(msg.From.Username) {
-					slog.Warn("unauthorized user", "username", msg.From.Username)
-				}
+	wg.Wait()
+	log.Fatal("responder stopped for some reason, this should not happen")
+}
 
-				// match the input to a social struct for scraping
-				matchedSocial := social.NewSocialInstance(msg.Text)
-				if matchedSocial == nil {
-					slog.Warn("no social media matched")
-					continue
-				}
-				matchedSocial.SetAppState(appState)
-
-				// split and cleanup the input
-				slice := func() []string {
-					slice := strings.Split(msg.Text, ",")
-					temp := make([]string, 0)
-					for _, item := range slice {
-						item = strings.TrimSpace(item)
-						if item != "" {
-							temp = append(temp, item)
-						}
-					}
-					return temp
-				}()
-
-				// analyze the input
-				var postURL, authorInfo, hashtags string
-				var err error
-				switch len(slice) {
-				case 0:
-					slog.Warn("no post URL found")
-					continue
-				case 1:
-					postURL = slice[0]
-					if err := matchedSocial.SetURL(postURL); err != nil {
-						slog.Warn("failed to set URL", "err", err)
-						continue
-					}
-					authorInfo, err = matchedSocial.GetUsername()
-					if err != nil {
-						slog.Warn("failed to get author", "err", err)
-						continue
-					}
-				case 2:
-					postURL = slice[0]
-					if err := matchedSocial.SetURL(postURL); err != nil {
-						slog.Warn("failed to set URL", "err", err)
-						continue
-					}
-					switch {
-					case strings.HasPrefix(slice[1], "#"):
-						hashtags = slice[1]
-					default:
-						authorInfo = slice[1]
-					}
-				case 3:
-					postURL = slice[0]
-					if err := matchedSocial.SetURL(postURL); err != nil {
-						slog.Warn("failed to set URL", "err", err)
-						continue
-					}
-					switch {
-					case strings.HasPrefix(slice[1], "#"):
-						authorInfo = slice[1]
-						hashtags = slice[2]
-					default:
-						authorInfo = slice[1]
-						hashtags = slice[2]
-					}
-				}
-				if err := matchedSocial.SetURL(postURL); err != nil {
-					slog.Warn("failed to set URL", "err", err)
-					continue
-				}
+// Process jobs one by one, waiting for new ones when the queue is empty
+func (r *responder) work() {
+	for {
+		job, err := r.db.ClaimJob()
+		if err != nil {
+			slog.Error("failed to claim job", "err", err)
+		}
+		if job == nil {
+			select {
+			case <-r.db.JobAdded():
+			case <-time.After(jobPollInterval):
+			}
+			continue
+		}
 
-				// scrape the content and media
-				mdContent, err := matchedSocial.GetMarkdownContent()
-				if err != nil {
-					slog.Error("failed to get HTML content", "err", err)
-					continue
-				}
-				media, err := matchedSocial.GetMedia()
-				if err != nil {
-					slog.Error("failed to get media", "err", err)
-					continue
-				}
+		if err := r.finishJob(job, r.handleJob(job)); err != nil {
+			slog.Error("failed to update job", "id", job.ID, "err", err)
+		}
+	}
+}
 
-				// add necessary data to the message struct
-				teleMsg := TelegramMessage{}
-				teleMsg.
-					SetContent(mdContent).
-					SetArtistNameAndUsername(authorInfo).
-					SetHashtags(hashtags).
-					SetMedia(media).
-					SetPostURL(postURL)
-
-				// getting the target channel
-				targetChannel := appState.GetTargetChannel()
-				if targetChannel == "" {
-					targetChannel = strconv.Itoa(msg.From.ID)
-				}
+// Put the job back in the queue for a moment while the lines before it in
+// the same message aren't published, so the worker is free for other jobs.
+// Once the order timeout passes the job goes anyway, so a stuck line doesn't
+// hold back the rest. Returns whether the job was deferred
+func (r *responder) deferForTurn(job *database.Job) (bool, error) {
+	timeout := r.appState.GetOrderTimeout()
+	if timeout <= 0 {
+		return false, nil
+	}
+	turn, err := r.db.IsJobTurn(job)
+	if err != nil {
+		slog.Warn("failed to check the order of the job", "id", job.ID, "err", err)
+		return false, nil
+	}
+	if turn {
+		return false, nil
+	}
 
-				// from the message struct serialize everything to a complete
-				// data package to be sent to Telegram
-				data, endPoint, err := teleMsg.ToData(targetChannel)
-				if err != nil {
-					slog.Error("failed to compose message", "err", err)
-					continue
-				}
+	now := time.Now()
+	if !job.TurnDeadline.IsZero() && now.After(job.TurnDeadline) {
+		slog.Warn("timed out waiting for the previous lines, publishing out of order", "id", job.ID)
+		return false, nil
+	}
+	if err := r.db.DeferJob(job.ID, now.Add(turnRetryDelay), now.Add(timeout)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
 
-				// init the request
-				url := "https://api.telegram.org/bot" + appState.GetBotToken() + "/" + string(endPoint)
-				resp, err := http.PostForm(url, data)
-				if err != nil {
-					slog.Error("failed to send message", "err", err)
-					continue
-				}
+// Put a scraped job in the posting queue of the target and tell the sender
+// where it stands
+func (r *responder) holdJob(job *database.Job, draft *database.Draft) error {
+	// the topics of a forum share the slots of their chat
+	target, _ := utils.SplitChat(draft.TargetChat)
+	if err := r.db.HoldJob(job.ID, target); err != nil {
+		return err
+	}
 
-				// read & log the response
-				var respBody struct {
-					OK          bool   `json:"ok"`
-					ErrorCode   int    `json:"error_code"`
-					Description string `json:"description"`
-				}
-				body, err := io.ReadAll(resp.Body)
-				if err != nil {
-					resp.Body.Close()
-					slog.Error("failed to read response body: ", "err", err)
-					continue
-				}
-				resp.Body.Close()
-				if err := json.Unmarshal(body, &respBody); err != nil {
-					slog.Error("failed to unmarshal response: ", "err", err)
-					continue
-				}
-				if !respBody.OK {
-					slog.Error("message not sent", "error_code", respBody.ErrorCode, "description", respBody.Description)
+	position := 0
+	if jobs, err := r.db.ListWaitingJobs(); err != nil {
+		slog.Warn("failed to list waiting jobs", "err", err)
+	} else {
+		for _, other := range jobs {
+			if other.Target == target {
+				position++
+			}
+			if other.ID == job.ID {
+				break
+			}
+		}
+	}
+	last, err := r.db.LastSlot(target)
+	if err != nil {
+		slog.Warn("failed to get the last slot", "err", err)
+	}
+	at := estimateSlot(r.appState.GetQueueSchedule(target), last, time.Now(), max(position, 1))
+
+	author := draft.AuthorInfo
+	if author == "" {
+		author = "the artist"
+	}
+	r.status.report(job, draft.TargetChat, fmt.Sprintf("⏳ Queued #%d at position %d, around %s, %d media by %s",
+		job.ID, position, at.Format(utils.ScheduleFormat), len(draft.KeptMedia()), author))
+	return errJobDeferred
+}
+
+// Mark a job as done, retry it later if it failed with a retryable error, or
+// give up on it
+func (r *responder) finishJob(job *database.Job, jobErr error) error {
+	switch {
+	case jobErr == nil:
+		return r.db.SetJobState(job.ID, database.JobStateDone, "")
+	case errors.Is(jobErr, errJobDeferred):
+		return nil
+	}
+
+	// a failed draft replaces the status of its target
+	statusChat := ""
+	if draft, err := r.db.GetDraft(job.ID); err == nil && draft != nil {
+		statusChat = draft.TargetChat
+	}
+
+	retryable, retryAfter := utils.IsRetryable(jobErr)
+	switch {
+	case !retryable:
+		slog.Error("job failed", "id", job.ID, "text", job.Message.Text, "err", jobErr)
+		r.status.report(job, statusChat, describeError(jobErr, job.Attempts))
+		return r.db.SetJobState(job.ID, database.JobStateFailed, jobErr.Error())
+	case job.Attempts >= r.appState.GetMaxRetries():
+		slog.Error("job ran out of retries", "id", job.ID, "text", job.Message.Text, "err", jobErr)
+		r.status.report(job, statusChat, describeError(jobErr, job.Attempts))
+		return r.db.SetJobState(job.ID, database.JobStateDead, jobErr.Error())
+	default:
+		delay := retryDelay(r.appState.GetRetryBaseDelay(), job.Attempts, retryAfter)
+		slog.Warn("job failed, retrying", "id", job.ID, "in", delay, "err", jobErr)
+		return r.db.RetryJob(job.ID, jobErr.Error(), time.Now().Add(delay))
+	}
+}
+
+// Double the base delay on each attempt up to a cap, randomly shortened by up
+// to half so retries of jobs that failed together don't happen together.
+// Never wait less than what the server asked for
+func retryDelay(base time.Duration, attempts int, retryAfter time.Duration) time.Duration {
+	delay := maxRetryDelay
+	if attempts < 32 && base<<attempts > 0 && base<<attempts < maxRetryDelay {
+		delay = base << attempts
+	}
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	return max(delay, retryAfter)
+}
+
+// Scrape one line of a message into a draft if it wasn't already, then
+// preview, schedule, queue or publish it depending on where it stands
+func (r *responder) handleJob(job *database.Job) error {
+	draft, err := r.db.GetDraft(job.ID)
+	if err != nil {
+		return err
+	}
+	if draft == nil {
+		drafts, err := r.prepareDrafts(job)
+		if err != nil || len(drafts) == 0 {
+			return err
+		}
+		draft = drafts[0]
+		if err := r.db.SaveDraft(draft); err != nil {
+			return err
+		}
+		// the other targets get a job of their own
+		if err := r.db.ForkJob(job, drafts[1:]); err != nil {
+			return err
+		}
+	}
+
+	// let the requester review the post before it goes anywhere, the job is
+	// queued again once it's approved
+	if r.appState.GetPreviewPosts() && !job.Approved {
+		if err := r.db.SetJobState(job.ID, database.JobStatePreview, ""); err != nil {
+			return err
+		}
+		if err := r.previews.Send(draft); err != nil {
+			return fmt.Errorf("failed to send preview: %w", err)
+		}
+		return errJobDeferred
+	}
+
+	// contributors' posts wait for a moderator, the job is queued again once
+	// it's approved
+	if r.appState.NeedsReview(job.Message.From.Username) && !job.Reviewed {
+		if err := r.db.SetJobState(job.ID, database.JobStateReview, ""); err != nil {
+			return err
+		}
+		if err := r.previews.SendReview(draft, job.Message.From.Username); err != nil {
+			return fmt.Errorf("failed to send for review: %w", err)
+		}
+		r.status.report(job, draft.TargetChat, fmt.Sprintf("👀 Sent #%d to the moderators for review", job.ID))
+		return errJobDeferred
+	}
+
+	// hold the job until its scheduled time, it comes back here once the
+	// scheduler queues it again
+	if !draft.ScheduleAt.IsZero() && job.ScheduledAt.IsZero() {
+		if err := r.db.ScheduleJob(job.ID, draft.ScheduleAt); err != nil {
+			return err
+		}
+		r.status.report(job, draft.TargetChat, fmt.Sprintf("🕒 Scheduled #%d for %s", job.ID, draft.ScheduleAt.Format(utils.ScheduleFormat)))
+		return errJobDeferred
+	}
+
+	// hold the post for the next free slot of the target's posting queue,
+	// scheduled posts and "+now" skip it
+	if r.appState.GetQueueSchedule(draft.TargetChat) != nil && job.Target == "" && job.ScheduledAt.IsZero() && !draft.SkipQueue {
+		return r.holdJob(job, draft)
+	}
+
+	return r.publish(job, draft)
+}
+
+// Parse and scrape one line of a message into one draft per target it's routed
+// to. Returns none when there's nothing to post, the sender is told why
+func (r *responder) prepareDrafts(job *database.Job) ([]*database.Draft, error) {
+	appState, msg := r.appState, job.Message
+	slog.Debug("received message", "from", msg.From.Username, "text", msg.Text)
+
+	// parse the input, then match its link to a social struct for scraping
+	line, err := input.Parse(msg.Text, job.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	matchedSocial := social.NewSocialInstance(line.URL)
+	if matchedSocial == nil {
+		return nil, errUnsupportedLink
+	}
+	matchedSocial.SetAppState(appState)
+	if err := matchedSocial.SetURL(line.URL); err != nil {
+		return nil, fmt.Errorf("failed to set URL: %w", err)
+	}
+	postURL, authorInfo, hashtags, flags := line.URL, line.AuthorInfo(), strings.Join(line.Hashtags, " "), line.Flags
+
+	// without credits in the input, credit the ArtistDB entry of the scraped
+	// handle, or the handle itself when there's none
+	var newArtist *database.ArtistStub
+	if authorInfo == "" {
+		if authorInfo, newArtist, err = r.resolveAuthor(matchedSocial); err != nil {
+			return nil, err
+		}
+	}
+
+	// the defaults the local registry has for the artist
+	artist := r.localArtist(matchedSocial)
+	if artist != nil {
+		hashtags = utils.MergeHashtags(hashtags, artist.Hashtags)
+	}
+
+	// the schedule is relative to when the link was sent
+	var scheduleAt time.Time
+	if line.Schedule != "" {
+		if scheduleAt, err = utils.ParseSchedule(line.Schedule, job.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	// scrape the content and media
+	mdContent, err := matchedSocial.GetMarkdownContent()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get HTML content: %w", err)
+	}
+	media, err := matchedSocial.GetMedia()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get media: %w", err)
+	}
+	rating, err := matchedSocial.GetRating()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rating: %w", err)
+	}
+	title, err := matchedSocial.GetTitle()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get title: %w", err)
+	}
+
+	// pick the targets, either the ones named in the input or the routed ones.
+	// Without any, the post is echoed back to its sender
+	routing := appState.GetRouting()
+	targets := make([]*utils.Target, 0)
+	for _, marker := range line.Targets {
+		target := routing.Target(marker)
+		if target == nil {
+			return nil, fmt.Errorf("unknown target >%s", marker)
+		}
+		targets = append(targets, target)
+	}
+	if len(targets) == 0 && artist != nil && artist.Target != "" {
+		if target := routing.Target(artist.Target); target != nil {
+			targets = append(targets, target)
+		} else {
+			slog.Warn("the default target of the artist doesn't exist, routing the post", "artist", artist.Username, "target", artist.Target)
+		}
+	}
+	if len(targets) == 0 {
+		targets = routing.Route(matchedSocial.GetSource(), rating, strings.Fields(hashtags))
+	}
+	if len(targets) == 0 {
+		targets = append(targets, &utils.Target{Chat: strconv.Itoa(msg.From.ID)})
+	}
+
+	canonicalURL := matchedSocial.GetCanonicalURL()
+	drafts := make([]*database.Draft, 0, len(targets))
+	for _, target := range targets {
+		if !flags["force"] && r.alreadyPosted(job, target.Chat, canonicalURL) {
+			continue
+		}
+		drafts = append(drafts, &database.Draft{
+			JobID:        job.ID,
+			PostURL:      postURL,
+			CanonicalURL: canonicalURL,
+			Source:       matchedSocial.GetSource(),
+			Title:        title,
+			Rating:       rating,
+			Content:      mdContent(`\`),
+			AuthorInfo:   authorInfo,
+			Hashtags:     hashtags,
+			Media:        media,
+			TargetChat:   target.ChatFor(strings.Fields(hashtags)),
+			TargetName:   target.Name,
+			ScheduleAt:   scheduleAt,
+			SkipQueue:    flags["now"],
+			Force:        flags["force"],
+			NewArtist:    newArtist,
+			Spoiler:      artist != nil && artist.Spoiler,
+			RequesterID:  msg.From.ID,
+			PreviewChat:  strconv.Itoa(msg.Chat.ID),
+		})
+	}
+	return drafts, nil
+}
+
+// Get the credits of a post from the handle of its author, as the canonical
+// ArtistDB entry when there's one, else as the entry of the local registry.
+// An artist nobody knows is credited by its handle, and returned as a stub to
+// add to ArtistDB once the post is published
+func (r *responder) resolveAuthor(matchedSocial social.Social) (string, *database.ArtistStub, error) {
+	handle, err := matchedSocial.GetUsername()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get author: %w", err)
+	}
+	source := matchedSocial.GetSource()
+
+	artist, lookupErr := r.artists.Lookup(source, handle)
+	if lookupErr != nil {
+		slog.Warn("failed to look up the artist", "handle", handle, "err", lookupErr)
+	}
+	if artist == nil {
+		if artist, err = r.db.FindLocalArtist(source, handle); err != nil {
+			slog.Warn("failed to look up the local artist", "handle", handle, "err", err)
+		}
+	}
+	if artist != nil {
+		return artist.AuthorInfo(), nil, nil
+	}
+	// unknown artists are only added when ArtistDB could tell they're unknown
+	if lookupErr != nil || r.appState.GetArtistDBWrite() == "" {
+		return handle, nil, nil
+	}
+	return handle, newArtistStub(matchedSocial, handle), nil
+}
+
+// Describe an artist ArtistDB doesn't know from its scraped profile
+func newArtistStub(matchedSocial social.Social, handle string) *database.ArtistStub {
+	profileURL, err := matchedSocial.GetProfileURL()
+	if err != nil {
+		slog.Warn("failed to get the artist's profile", "handle", handle, "err", err)
+	}
+	displayName, err := matchedSocial.GetDisplayName()
+	if err != nil || displayName == "" {
+		displayName = handle
+	}
+	return &database.ArtistStub{
+		Source:      matchedSocial.GetSource(),
+		Handle:      handle,
+		ProfileURL:  profileURL,
+		DisplayName: displayName,
+	}
+}
+
+// Get the record of the author of a post in the local registry, nil when
+// there's none
+func (r *responder) localArtist(matchedSocial social.Social) *database.Artist {
+	handle, err := matchedSocial.GetUsername()
+	if err != nil {
+		return nil
+	}
+	artist, err := r.db.FindLocalArtist(matchedSocial.GetSource(), handle)
+	if err != nil {
+		slog.Warn("failed to look up the local artist", "handle", handle, "err", err)
+	}
+	return artist
+}
+
+// Add an artist ArtistDB doesn't know and tell the sender about it. Returns
+// the new entry, nil when there's none
+func (r *responder) registerArtist(msg utils.IncomingMessage, stub *database.ArtistStub) *database.Artist {
+	artist, registered, err := r.artists.Register(stub.Source, stub.Handle, stub.ProfileURL, stub.DisplayName)
+	switch {
+	case err != nil:
+		slog.Warn("failed to add the artist to ArtistDB", "handle", stub.Handle, "err", err)
+	case !registered:
+	case artist != nil:
+		link := utils.ArtistLink(r.appState.GetArtistDBDomain(), artist.Username, artist.DisplayName, stub.Source)
+		r.client.Reply(msg, fmt.Sprintf("Created the ArtistDB entry of %s: %s", stub.Handle, link))
+	default:
+		r.client.Reply(msg, fmt.Sprintf("Suggested %s to ArtistDB, the post credits the handle until it's accepted", stub.Handle))
+	}
+	return artist
+}
+
+// Check whether a post was already posted to a target, telling the sender
+// when it was
+func (r *responder) alreadyPosted(job *database.Job, targetChat string, canonicalURL string) bool {
+	// the post history is per chat, whatever the topic
+	chat, _ := utils.SplitChat(targetChat)
+	existingPost, err := r.db.FindPostByURL(chat, canonicalURL)
+	if err != nil {
+		slog.Warn("failed to look up the post history", "err", err)
+	}
+	if existingPost == nil {
+		return false
+	}
+	r.status.report(job, targetChat, "⚠️ "+alreadyPostedText(existingPost)+", add +force to post it again")
+	return true
+}
+
+// Tell when a post of the same URL was posted
+func alreadyPostedText(post *database.Post) string {
+	if post.Pending {
+		return "Already being posted by #" + strconv.FormatUint(post.JobID, 10)
+	}
+	text := "Already posted " + post.PostedAt.Format(time.DateOnly)
+	if link := post.Link(); link != "" {
+		text += ": " + link
+	}
+	return text
+}
+
+// Tell when a similar image was posted
+func similarImageText(post *database.Post) string {
+	text := "a similar image was already posted " + post.PostedAt.Format(time.DateOnly)
+	if link := post.Link(); link != "" {
+		text += ": " + link
+	}
+	return text
+}
+
+// Send a draft to its target channel and remember it in the post history
+func (r *responder) publish(job *database.Job, draft *database.Draft) error {
+	// nothing is downloaded before it's the job's turn
+	deferred, err := r.deferForTurn(job)
+	if err != nil {
+		return err
+	}
+	if deferred {
+		return errJobDeferred
+	}
+
+	// download and post-process the media which need it, hashing the
+	// photos on the way
+	media, imageHashes, cleanup, err := r.processor.Process(draft.KeptMedia(), true)
+	if err != nil {
+		slog.Warn("failed to process media, sending them as is", "err", err)
+	}
+	defer cleanup()
+
+	// the post history is per chat, whatever the topic
+	chat, _ := utils.SplitChat(draft.TargetChat)
+
+	// from the message struct serialize everything to complete data
+	// packages to be sent to Telegram, one after another
+	teleMsg := draftMessage(r.appState, draft, media)
+	requests, err := teleMsg.ToData(draft.TargetChat)
+	if err != nil {
+		return fmt.Errorf("failed to compose message: %w", err)
+	}
+
+	// another job of the same post may have been published since this one
+	// was scraped, its place in the history is taken before sending
+	post := &database.Post{
+		PostURL:     draft.CanonicalURL,
+		Source:      draft.Source,
+		Author:      teleMsg.GetUsername(),
+		TargetChat:  chat,
+		PostedAt:    time.Now(),
+		ImageHashes: imageHashes,
+		JobID:       job.ID,
+	}
+	reservation, err := r.db.ReservePost(post, database.DuplicateCheck{
+		Force:         draft.Force,
+		RefuseSimilar: r.appState.GetRefuseDuplicates() && !draft.Force,
+		Threshold:     r.appState.GetDuplicateThreshold(),
+	})
+	if err != nil {
+		return err
+	}
+	notice := ""
+	switch {
+	case !reservation.Saved && reservation.SameURL != nil:
+		r.status.report(job, draft.TargetChat, "⚠️ "+alreadyPostedText(reservation.SameURL)+", add +force to post it again")
+	case !reservation.Saved:
+		r.status.report(job, draft.TargetChat, "⚠️ Not posted, "+similarImageText(reservation.Similar)+", add +force to post it anyway")
+	case reservation.Similar != nil:
+		notice = similarImageText(reservation.Similar)
+	}
+	if !reservation.Saved {
+		if err := r.db.DeleteDraft(job.ID); err != nil {
+			slog.Warn("failed to delete draft", "err", err)
+		}
+		return nil
+	}
+
+	// an unknown artist is only added to ArtistDB once its post is sure to
+	// go out, which then credits the new entry
+	if draft.NewArtist != nil {
+		if artist := r.registerArtist(job.Message, draft.NewArtist); artist != nil {
+			draft.AuthorInfo = artist.AuthorInfo()
+			if requests, err = draftMessage(r.appState, draft, media).ToData(draft.TargetChat); err != nil {
+				if err := r.db.DeletePost(post.ID); err != nil {
+					slog.Error("failed to release the post", "err", err)
 				}
+				return fmt.Errorf("failed to compose message: %w", err)
 			}
-		}()
+		}
 	}
 
-	wg.Wait()
-	log.Fatal("responder stopped for some reason, this should not happen")
+	if err := r.db.SetJobState(job.ID, database.JobStateSending, ""); err != nil {
+		return err
+	}
+	messageIDs := make([]int, 0)
+	sent := 0
+	var sendErr error
+	for _, request := range requests {
+		ids, err := r.client.Send(request)
+		if err != nil {
+			// keep what was already sent in the history, so it's not
+			// posted again in full
+			if sent > 0 {
+				slog.Error("message partially sent", "endpoint", request.EndPoint, "err", err)
+				sendErr = err
+				break
+			}
+			if err := r.db.DeletePost(post.ID); err != nil {
+				slog.Error("failed to release the post", "err", err)
+			}
+			return fmt.Errorf("message not sent to %s: %w", request.EndPoint, err)
+		}
+		messageIDs = append(messageIDs, ids...)
+		sent++
+	}
+
+	// remember what was posted for duplicate detection
+	if err := r.db.CompletePost(post.ID, messageIDs); err != nil {
+		slog.Error("failed to save post", "err", err)
+	}
+	if err := r.db.DeleteDraft(job.ID); err != nil {
+		slog.Warn("failed to delete draft", "err", err)
+	}
+
+	r.status.report(job, draft.TargetChat, publishedText(draft.TargetChat, messageIDs, sent, len(requests), sendErr, notice))
+	return nil
+}
+
+// Tell where a post was published, warning when only the first `sent` of its
+// `total` messages went through
+func publishedText(chat string, messageIDs []int, sent int, total int, sendErr error, notice string) string {
+	text := "✅ Posted to " + chat
+	if sent < total {
+		text = fmt.Sprintf("⚠️ Partly posted to %s, %d of %d messages sent", chat, sent, total)
+	}
+	if len(messageIDs) > 0 {
+		if link := utils.MessageLink(chat, messageIDs[0]); link != "" {
+			text += ": " + link
+		}
+	}
+	if sendErr != nil {
+		text += ", the rest failed: " + sendErr.Error()
+	}
+	if notice != "" {
+		text += ", although " + notice
+	}
+	return text
 }
@@ -0,0 +1,61 @@
+package telegram_test
+
+import (
+	"errors"
+	"social-2-telego/telegram"
+	"testing"
+	"time"
+)
+
+func TestPublishedText(t *testing.T) {
+	cases := []struct {
+		name       string
+		messageIDs []int
+		sent       int
+		sendErr    error
+		expected   string
+	}{
+		{"fully sent", []int{42, 43}, 2, nil, "✅ Posted to @channel: https://t.me/channel/42"},
+		{"partly sent", []int{42}, 1, errors.New("Bad Request"), "⚠️ Partly posted to @channel, 1 of 2 messages sent: https://t.me/channel/42, the rest failed: Bad Request"},
+		{"no message IDs", nil, 2, nil, "✅ Posted to @channel"},
+	}
+	for _, c := range cases {
+		if text := telegram.PublishedText("@channel", c.messageIDs, c.sent, 2, c.sendErr, ""); text != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, text)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	base := 30 * time.Second
+	cases := []struct {
+		attempts int
+		longest  time.Duration
+	}{
+		{0, base},
+		{1, 2 * base},
+		{3, 8 * base},
+		// capped once doubling passes the limit, or overflows
+		{7, telegram.MaxRetryDelay},
+		{40, telegram.MaxRetryDelay},
+		{100, telegram.MaxRetryDelay},
+	}
+	for _, c := range cases {
+		shortest, longest := time.Duration(1<<62), time.Duration(0)
+		for range 200 {
+			delay := telegram.RetryDelay(base, c.attempts, 0)
+			shortest, longest = min(shortest, delay), max(longest, delay)
+		}
+		if shortest < c.longest/2 || longest > c.longest {
+			t.Errorf("attempt %d: expected delays between %v and %v, got %v to %v", c.attempts, c.longest/2, c.longest, shortest, longest)
+		}
+		if shortest == longest {
+			t.Errorf("attempt %d: expected jittered delays, always got %v", c.attempts, shortest)
+		}
+	}
+
+	// the server's wait wins when it's longer
+	if delay := telegram.RetryDelay(base, 0, 10*time.Minute); delay != 10*time.Minute {
+		t.Errorf("Expected to wait what the server asked, got %v", delay)
+	}
+}
@@ -0,0 +1,89 @@
+package telegram
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"social-2-telego/database"
+	"social-2-telego/utils"
+)
+
+// List the pending scheduled posts, soonest first
+func (c *Commands) listScheduledJobs(_ utils.IncomingMessage, _ []string) string {
+	jobs, err := c.db.ListJobs(database.JobStateScheduled)
+	if err != nil {
+		slog.Error("failed to list scheduled jobs", "err", err)
+		return "Failed to list the scheduled posts"
+	}
+	if len(jobs) == 0 {
+		return "No scheduled posts"
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].ScheduledAt.Before(jobs[j].ScheduledAt)
+	})
+
+	lines := []string{fmt.Sprintf("%d scheduled post(s)", len(jobs))}
+	for i, job := range jobs {
+		if i == maxListedItems {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(jobs)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("#%d %s\n  %s", job.ID, job.ScheduledAt.Local().Format(utils.ScheduleFormat), job.Message.Text))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Parse the job ID argument of a command, "#" is optional
+func parseJobID(arg string) (uint64, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(arg, "#"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a job ID", arg)
+	}
+	return id, nil
+}
+
+// Move a scheduled post to another time
+func (c *Commands) moveScheduledJob(_ utils.IncomingMessage, args []string) string {
+	if len(args) < 2 {
+		return "Usage: " + c.commands["move"].usage
+	}
+	id, err := parseJobID(args[0])
+	if err != nil {
+		return err.Error()
+	}
+
+	// "at" is optional, "/move 12 18:00" reads just fine
+	spec := strings.Join(args[1:], " ")
+	if !utils.IsSchedule(spec) {
+		spec = "at " + spec
+	}
+	at, err := utils.ParseSchedule(spec, time.Now())
+	if err != nil {
+		return err.Error()
+	}
+
+	if err := c.db.RescheduleJob(id, at); err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("Moved #%d to %s", id, at.Format(utils.ScheduleFormat))
+}
+
+// Cancel a scheduled post
+func (c *Commands) cancelScheduledJob(_ utils.IncomingMessage, args []string) string {
+	if len(args) != 1 {
+		return "Usage: " + c.commands["cancel"].usage
+	}
+	id, err := parseJobID(args[0])
+	if err != nil {
+		return err.Error()
+	}
+
+	if err := c.db.CancelJob(id); err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("Cancelled #%d", id)
+}
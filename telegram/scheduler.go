@@ -0,0 +1,78 @@
+package telegram
+
+import (
+	"log/slog"
+	"time"
+
+	"social-2-telego/database"
+	"social-2-telego/utils"
+)
+
+// How often the scheduler looks for jobs whose time has come
+const schedulerInterval = 10 * time.Second
+
+// Continuously queue the scheduled jobs whose time has come and the first
+// job of each posting queue when a new slot starts, the workers publish them
+// like any other job
+func Scheduler(appState *utils.AppState, db *database.Database) {
+	for {
+		released, err := db.ReleaseDueJobs(time.Now())
+		if err != nil {
+			slog.Error("failed to release scheduled jobs", "err", err)
+		}
+		if released > 0 {
+			slog.Info("released scheduled jobs", "count", released)
+		}
+		releaseSlots(appState, db, time.Now())
+		time.Sleep(schedulerInterval)
+	}
+}
+
+// Release the first waiting job of every posting queue whose current slot is
+// still free
+func releaseSlots(appState *utils.AppState, db *database.Database, now time.Time) {
+	jobs, err := db.ListWaitingJobs()
+	if err != nil {
+		slog.Error("failed to list waiting jobs", "err", err)
+		return
+	}
+
+	targets := make(map[string]bool)
+	for _, job := range jobs {
+		targets[job.Target] = true
+	}
+	for target := range targets {
+		// the queue might have been disabled since, publish right away
+		slot := now
+		if schedule := appState.GetQueueSchedule(target); schedule != nil {
+			slot = schedule.Current(now)
+		}
+		if slot.IsZero() {
+			continue
+		}
+
+		job, err := db.ReleaseSlot(target, slot)
+		if err != nil {
+			slog.Error("failed to release slot", "target", target, "err", err)
+			continue
+		}
+		if job != nil {
+			slog.Info("released job for its slot", "id", job.ID, "target", target, "slot", slot)
+		}
+	}
+}
+
+// Estimate when the job at `position` (from 1) of a posting queue is
+// published, given the last slot a job was released in
+func estimateSlot(schedule *utils.SlotSchedule, last time.Time, now time.Time, position int) time.Time {
+	at := schedule.Current(now)
+	if at.IsZero() || !at.After(last) {
+		at = schedule.Next(now)
+	} else {
+		at = now
+	}
+	for i := 1; i < position; i++ {
+		at = schedule.Next(at)
+	}
+	return at
+}
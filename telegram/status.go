@@ -0,0 +1,113 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"social-2-telego/database"
+	"social-2-telego/input"
+	"social-2-telego/utils"
+)
+
+// Returned for lines whose link isn't from a supported site
+var errUnsupportedLink = errors.New("unsupported link, only 𝕏 and FurAffinity posts can be posted")
+
+// Keeps one reply per incoming message telling how each of its links went,
+// edited as their jobs move on
+type statusReporter struct {
+	db     *database.Database
+	client *Client
+	// Jobs of the same message finish on different workers, the reply must
+	// be sent only once
+	mu sync.Mutex
+}
+
+// Create a new statusReporter instance
+func newStatusReporter(db *database.Database, client *Client) *statusReporter {
+	return &statusReporter{db: db, client: client}
+}
+
+// Set the status of the link of a job for a target chat, empty when it's not
+// known yet, and update the reply of its message. Errors are only logged
+func (s *statusReporter) report(job *database.Job, chat string, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, err := s.db.SetLinkStatus(job, chat, text)
+	if err != nil {
+		slog.Error("failed to save the status", "id", job.ID, "err", err)
+		return
+	}
+	if status.ReplyID != 0 {
+		s.client.EditText(strconv.Itoa(job.Message.Chat.ID), status.ReplyID, renderStatus(status))
+		return
+	}
+	replyID, err := s.client.SendReply(job.Message, renderStatus(status))
+	if err != nil {
+		slog.Error("failed to send the status", "id", job.ID, "err", err)
+		return
+	}
+	if err := s.db.SetStatusReply(job.Message, replyID); err != nil {
+		slog.Error("failed to save the status", "id", job.ID, "err", err)
+	}
+}
+
+// Render the lines of a status in the order of the links, followed by how
+// many links are still going
+func renderStatus(status *database.MessageStatus) string {
+	keys := make([]string, 0, len(status.Lines))
+	seqs := make(map[int]bool)
+	for key, line := range status.Lines {
+		keys = append(keys, key)
+		seqs[line.Seq] = true
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := status.Lines[keys[i]], status.Lines[keys[j]]
+		if a.Seq != b.Seq {
+			return a.Seq < b.Seq
+		}
+		return keys[i] < keys[j]
+	})
+
+	lines := make([]string, 0, len(keys)+1)
+	for _, key := range keys {
+		line := status.Lines[key]
+		if status.Links > 1 {
+			lines = append(lines, fmt.Sprintf("%d. %s", line.Seq+1, line.Text))
+			continue
+		}
+		lines = append(lines, line.Text)
+	}
+	if pending := status.Links - len(seqs); pending > 0 {
+		lines = append(lines, fmt.Sprintf("⏳ %d more link(s) in progress", pending))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Tell in plain language why a job failed
+func describeError(err error, attempts int) string {
+	var inputErr *input.Error
+	switch {
+	case errors.As(err, &inputErr):
+		return fmt.Sprintf("❌ Couldn't read the line at %s", inputErr)
+	case errors.Is(err, errUnsupportedLink):
+		return "❌ " + capitalize(err.Error())
+	}
+	if retryable, _ := utils.IsRetryable(err); retryable {
+		return fmt.Sprintf("❌ Gave up after %d attempt(s): %v", attempts, err)
+	}
+	return "❌ " + capitalize(err.Error())
+}
+
+// Uppercase the first letter of a message
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -1,10 +1,13 @@
 package telegram
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
+	"slices"
 	"social-2-telego/social"
 	"social-2-telego/utils"
+	"strconv"
 	"strings"
 )
 
@@ -17,9 +20,40 @@ const (
 	SendTypeMessage    SendType = "sendMessage"
 	SendTypePhoto      SendType = "sendPhoto"
 	SendTypeVideo      SendType = "sendVideo"
+	SendTypeAnimation  SendType = "sendAnimation"
+	SendTypeDocument   SendType = "sendDocument"
+	SendTypeAudio      SendType = "sendAudio"
 	SendTypeMediaGroup SendType = "sendMediaGroup"
+
+	SendTypeEditMessageText     SendType = "editMessageText"
+	SendTypeDeleteMessages      SendType = "deleteMessages"
+	SendTypeAnswerCallbackQuery SendType = "answerCallbackQuery"
 )
 
+// Telegram rejects media groups with more than 10 items
+const maxMediaGroupSize = 10
+
+// One request to the Bot API, a message might need several of them
+type TelegramRequest struct {
+	EndPoint SendType
+	Data     url.Values
+	// Local files to upload as multipart/form-data, keyed by the field name
+	Files map[string]string
+}
+
+// One item of the "media" field of sendMediaGroup
+type inputMedia struct {
+	Type       social.MediaType `json:"type"`
+	Media      string           `json:"media"`
+	Thumbnail  string           `json:"thumbnail,omitempty"`
+	Caption    string           `json:"caption,omitempty"`
+	ParseMode  string           `json:"parse_mode,omitempty"`
+	HasSpoiler bool             `json:"has_spoiler,omitempty"`
+	Width      int              `json:"width,omitempty"`
+	Height     int              `json:"height,omitempty"`
+	Duration   int              `json:"duration,omitempty"`
+}
+
 type TelegramMessage struct {
 	content     func(string) string
 	postURL     string
@@ -27,22 +61,32 @@ type TelegramMessage struct {
 	displayName string
 	hashtags    []string
 	media       []social.ScrapedMedia
+	title       string
+	source      string
+	rating      string
+	spoiler     bool
+	caption     *utils.CaptionTemplate
+	artistDB    string
 }
 
+// The link to the artist when no ArtistDB is set
+const defaultArtistDB = "https://artistdb.delnegend.com/{username}"
+
 // Set the content from the raw HTML to the message
 func (tmc *TelegramMessage) SetContent(content func(string) string) *TelegramMessage {
 	tmc.content = content
 	return tmc
 }
 
-// Set the hashtags to the message, separated by spaces
+// Set the hashtags to the message, separated by spaces, the repeated ones
+// are dropped
 func (tmc *TelegramMessage) SetHashtags(hashtags string) *TelegramMessage {
 	if hashtags == "" {
 		return tmc
 	}
 	for _, item := range strings.Fields(hashtags) {
 		hashtag := strings.TrimSpace(strings.TrimPrefix(item, "#"))
-		if hashtag != "" {
+		if hashtag != "" && !slices.ContainsFunc(tmc.hashtags, func(existing string) bool { return strings.EqualFold(existing, hashtag) }) {
 			tmc.hashtags = append(tmc.hashtags, hashtag)
 		}
 	}
@@ -55,6 +99,7 @@ func (tmc *TelegramMessage) SetHashtags(hashtags string) *TelegramMessage {
 //	"lorem ipsum" -> username = lorem, displayName = ipsum
 //	"lorem @ipsum" -> username = ipsum, displayName = lorem
 //	"lorem" || "@lorem" -> username = lorem, displayName = lorem
+//	"@lorem ipsum dolor" -> username = lorem, displayName = ipsum dolor
 func (tmc *TelegramMessage) SetArtistNameAndUsername(s string) *TelegramMessage {
 	slice := strings.Fields(s)
 	switch len(slice) {
@@ -79,6 +124,15 @@ func (tmc *TelegramMessage) SetArtistNameAndUsername(s string) *TelegramMessage
 		}
 		return tmc
 	default:
+		// display names of several words need the username marked with @
+		switch {
+		case strings.HasPrefix(slice[0], "@"):
+			tmc.username = slice[0][1:]
+			tmc.displayName = strings.Join(slice[1:], " ")
+		case strings.HasPrefix(slice[len(slice)-1], "@"):
+			tmc.username = slice[len(slice)-1][1:]
+			tmc.displayName = strings.Join(slice[:len(slice)-1], " ")
+		}
 		return tmc
 	}
 }
@@ -95,8 +149,46 @@ func (tmc *TelegramMessage) SetPostURL(url string) *TelegramMessage {
 	return tmc
 }
 
-// Serialize the content (aka caption, or the message) to a string
-func (tmc *TelegramMessage) serializeContent(doubleEscape bool) (string, error) {
+// Hide the photos and videos of the message behind a spoiler
+func (tmc *TelegramMessage) SetSpoiler(spoiler bool) *TelegramMessage {
+	tmc.spoiler = spoiler
+	return tmc
+}
+
+// Set the title of the post, the site it's from and how explicit it is to the
+// message, for the caption template
+func (tmc *TelegramMessage) SetPostInfo(title string, source string, rating string) *TelegramMessage {
+	tmc.title = title
+	tmc.source = source
+	tmc.rating = rating
+	return tmc
+}
+
+// Set the layout of the caption, nil for the default one
+func (tmc *TelegramMessage) SetCaptionTemplate(caption *utils.CaptionTemplate) *TelegramMessage {
+	tmc.caption = caption
+	return tmc
+}
+
+// Set the URL of the artists' pages, with the placeholders of ARTIST_DB_DOMAIN
+func (tmc *TelegramMessage) SetArtistDB(urlTemplate string) *TelegramMessage {
+	tmc.artistDB = urlTemplate
+	return tmc
+}
+
+// Get the artist's username
+func (tmc *TelegramMessage) GetUsername() string {
+	return tmc.username
+}
+
+// Serialize the content (aka caption, or the message) to a string, with or
+// without the alt texts of the media
+func (tmc *TelegramMessage) serializeContent(withAltTexts bool) (string, error) {
+	return tmc.serialize(tmc.content(`\`), withAltTexts)
+}
+
+// Serialize the message with the given MarkdownV2 content of the post
+func (tmc *TelegramMessage) serialize(content string, withAltTexts bool) (string, error) {
 	switch {
 	case tmc.postURL == "":
 		return "", fmt.Errorf("TelegramMsgComposer.Serialize: postURL is empty")
@@ -104,92 +196,293 @@ func (tmc *TelegramMessage) serializeContent(doubleEscape bool) (string, error)
 		return "", fmt.Errorf("TelegramMsgComposer.Serialize: username is empty")
 	}
 
-	escapeChar := `\`
-	if doubleEscape {
-		escapeChar = `\\`
+	caption := tmc.caption
+	if caption == nil {
+		var err error
+		if caption, err = utils.NewCaptionTemplate("", ""); err != nil {
+			return "", fmt.Errorf("TelegramMsgComposer.Serialize: %w", err)
+		}
 	}
 
-	content := tmc.content(escapeChar)
-	if content != "" {
-		content = fmt.Sprintf(">%s\n", strings.Join(strings.Split(content, "\n"), "\n>"))
+	artistDB := tmc.artistDB
+	if artistDB == "" {
+		artistDB = defaultArtistDB
 	}
 
-	hashtags := func() string {
-		if len(tmc.hashtags) == 0 {
-			return ""
-		}
-		hashtags := strings.TrimPrefix(strings.Join(tmc.hashtags, ` #`), ` `)
-		return fmt.Sprintf(" %s[%s%s]", escapeChar, hashtags, escapeChar)
-	}()
+	altTexts := tmc.altTexts()
+	if !withAltTexts {
+		altTexts = nil
+	}
+	return caption.Render(utils.CaptionData{
+		Content:  utils.FormatContent(content, caption.ParseMode()),
+		PostURL:  tmc.postURL,
+		Title:    tmc.title,
+		Source:   tmc.source,
+		Rating:   tmc.rating,
+		Hashtags: tmc.hashtags,
+		Author: utils.CaptionAuthor{
+			Username:    tmc.username,
+			DisplayName: tmc.displayName,
+			URL:         utils.ArtistLink(artistDB, tmc.username, tmc.displayName, tmc.source),
+		},
+		AltTexts: altTexts,
+	})
+}
 
-	return fmt.Sprintf("%s[Post](%s) %s| [%s](https://artistdb.delnegend.com/%s)%s",
-		content,
-		utils.EscapeSpecialChars(tmc.postURL, escapeChar),
-		escapeChar,
-		utils.EscapeSpecialChars(tmc.displayName, escapeChar),
-		utils.EscapeSpecialChars(tmc.username, escapeChar),
-		hashtags,
-	), nil
+// Get the alt texts of the media, in order
+func (tmc *TelegramMessage) altTexts() []string {
+	altTexts := make([]string, 0, len(tmc.media))
+	for _, media := range tmc.media {
+		altTexts = append(altTexts, media.AltText)
+	}
+	return altTexts
 }
 
-// Return a fully processed data to be sent to Telegram
-func (tmc *TelegramMessage) ToData(chatID string) (url.Values, SendType, error) {
-	data := url.Values{
-		"chat_id":              {chatID},
-		"parse_mode":           {"MarkdownV2"},
-		"disable_notification": {"true"},
+// Split the content into what fits in a caption and the text messages sent
+// after the media. Long alt texts get a message of their own, and so does the
+// rest of the caption when it's still too long without them
+func (tmc *TelegramMessage) splitCaption(content string) (string, []string, error) {
+	if utils.TextLength(content, tmc.parseMode()) <= utils.MaxCaptionLength {
+		return content, nil, nil
 	}
 
-	switch len(tmc.media) {
-	case 0:
-		content, err := tmc.serializeContent(false)
-		if err != nil {
-			return data, "", err
+	withoutAltTexts, err := tmc.serializeContent(false)
+	if err != nil {
+		return "", nil, err
+	}
+	texts := make([]string, 0)
+	caption := withoutAltTexts
+	if utils.TextLength(withoutAltTexts, tmc.parseMode()) > utils.MaxCaptionLength {
+		caption = ""
+		texts = append(texts, withoutAltTexts)
+	}
+	altTexts := utils.TruncateAltTexts(tmc.altTexts(), tmc.parseMode())
+	if block := utils.RenderAltTexts(altTexts, tmc.parseMode()); block != "" {
+		texts = append(texts, block)
+	}
+	return caption, texts, nil
+}
+
+// Shorten the text of a post without media to fit in a message. Such a post
+// has no alt texts to move out, so its content is cut like the alt texts are
+func (tmc *TelegramMessage) shortenText(text string) (string, error) {
+	excess := utils.TextLength(text, tmc.parseMode()) - utils.MaxMessageLength
+	if excess <= 0 {
+		return text, nil
+	}
+	content := tmc.content(`\`)
+	return tmc.serialize(utils.TruncateContent(content, utils.TextLength(content, utils.ParseModeMarkdownV2)-excess), true)
+}
+
+// Get the parse mode of the caption
+func (tmc *TelegramMessage) parseMode() string {
+	if tmc.caption == nil {
+		return utils.ParseModeMarkdownV2
+	}
+	return tmc.caption.ParseMode()
+}
+
+// Which album a media type can be grouped into. Telegram only allows photos
+// and videos to be mixed, documents and audio must stay with their own kind
+// and animations can't be part of a media group at all
+func albumKind(mediaType social.MediaType) string {
+	switch mediaType {
+	case social.MediaTypePhoto, social.MediaTypeVideo:
+		return "visual"
+	case social.MediaTypeDocument:
+		return "document"
+	case social.MediaTypeAudio:
+		return "audio"
+	default:
+		return ""
+	}
+}
+
+// Split the media into groups which can each be sent in one request, keeping
+// the original order inside each group and the groups ordered by their first
+// appearance
+func groupMedia(media []social.ScrapedMedia) [][]social.ScrapedMedia {
+	groups := make([][]social.ScrapedMedia, 0)
+	groupIndex := make(map[string]int)
+	for _, item := range media {
+		kind := albumKind(item.MediaType)
+		if kind == "" {
+			groups = append(groups, []social.ScrapedMedia{item})
+			continue
 		}
-		data.Add("text", content)
-		return data, SendTypeMessage, nil
-	case 1:
-		var endPoint SendType
-		content, err := tmc.serializeContent(false)
-		if err != nil {
-			return data, "", err
+		index, ok := groupIndex[kind]
+		if !ok || len(groups[index]) == maxMediaGroupSize {
+			groupIndex[kind] = len(groups)
+			groups = append(groups, []social.ScrapedMedia{item})
+			continue
 		}
+		groups[index] = append(groups[index], item)
+	}
+	return groups
+}
 
-		switch tmc.media[0].MediaType {
-		case social.MediaTypePhoto:
-			endPoint = SendTypePhoto
-		case social.MediaTypeVideo:
-			endPoint = SendTypeVideo
-		default:
-			return data, "", fmt.Errorf("invalid media type")
+// Only photos, videos and animations can be hidden behind a spoiler
+func canHaveSpoiler(mediaType social.MediaType) bool {
+	switch mediaType {
+	case social.MediaTypePhoto, social.MediaTypeVideo, social.MediaTypeAnimation:
+		return true
+	default:
+		return false
+	}
+}
+
+// Return the data of a single media message, the caption is only added when
+// it's not empty
+func singleMediaData(chatID string, parseMode string, media social.ScrapedMedia, caption string, spoiler bool) (TelegramRequest, error) {
+	endPoint, ok := map[social.MediaType]SendType{
+		social.MediaTypePhoto:     SendTypePhoto,
+		social.MediaTypeVideo:     SendTypeVideo,
+		social.MediaTypeAnimation: SendTypeAnimation,
+		social.MediaTypeDocument:  SendTypeDocument,
+		social.MediaTypeAudio:     SendTypeAudio,
+	}[media.MediaType]
+	if !ok {
+		return TelegramRequest{}, fmt.Errorf("invalid media type %q", media.MediaType)
+	}
+
+	request := TelegramRequest{EndPoint: endPoint, Data: newRequestData(chatID, parseMode), Files: map[string]string{}}
+	if media.FilePath != "" {
+		request.Files[string(media.MediaType)] = media.FilePath
+	} else {
+		request.Data.Add(string(media.MediaType), media.MediaUrl)
+	}
+	if media.ThumbnailPath != "" {
+		request.Data.Add("thumbnail", "attach://thumbnail")
+		request.Files["thumbnail"] = media.ThumbnailPath
+	}
+	for key, value := range map[string]int{
+		"width":    media.Width,
+		"height":   media.Height,
+		"duration": media.Duration,
+	} {
+		if value > 0 {
+			request.Data.Add(key, strconv.Itoa(value))
 		}
-		data.Add(string(tmc.media[0].MediaType), tmc.media[0].MediaUrl)
-		data.Add("caption", content)
+	}
+	if spoiler && canHaveSpoiler(media.MediaType) {
+		request.Data.Add("has_spoiler", "true")
+	}
+	if caption != "" {
+		request.Data.Add("caption", caption)
+	}
+	return request, nil
+}
 
-		return data, endPoint, nil
-	default:
-		content, err := tmc.serializeContent(true)
+// Return the data of a media group, the caption is added to the first media
+// when it's not empty
+func mediaGroupData(chatID string, parseMode string, group []social.ScrapedMedia, caption string, spoiler bool) (TelegramRequest, error) {
+	files := map[string]string{}
+	result := make([]inputMedia, 0, len(group))
+	for i, media := range group {
+		item := inputMedia{
+			Type:       media.MediaType,
+			Media:      media.MediaUrl,
+			Width:      media.Width,
+			Height:     media.Height,
+			Duration:   media.Duration,
+			HasSpoiler: spoiler && canHaveSpoiler(media.MediaType),
+		}
+		if media.FilePath != "" {
+			name := fmt.Sprintf("file%d", i)
+			item.Media = "attach://" + name
+			files[name] = media.FilePath
+		}
+		if media.ThumbnailPath != "" {
+			name := fmt.Sprintf("thumbnail%d", i)
+			item.Thumbnail = "attach://" + name
+			files[name] = media.ThumbnailPath
+		}
+		// There's no "text", must add "caption" for the first media instead
+		if i == 0 && caption != "" {
+			item.Caption = caption
+			item.ParseMode = parseMode
+		}
+		result = append(result, item)
+	}
+
+	mediaJSON, err := json.Marshal(result)
+	if err != nil {
+		return TelegramRequest{}, fmt.Errorf("failed to marshal media group: %w", err)
+	}
+
+	data := newRequestData(chatID, parseMode)
+	data.Add("media", string(mediaJSON))
+	return TelegramRequest{EndPoint: SendTypeMediaGroup, Data: data, Files: files}, nil
+}
+
+// Return the base data shared by every request of a message
+func newRequestData(chatID string, parseMode string) url.Values {
+	data := chatData(chatID)
+	data.Add("parse_mode", parseMode)
+	data.Add("disable_notification", "true")
+	return data
+}
+
+// Return the data addressing a chat, and the forum topic of a
+// "chat_id:thread_id" chat which every send method must be given
+func chatData(chat string) url.Values {
+	chatID, threadID := utils.SplitChat(chat)
+	data := url.Values{"chat_id": {chatID}}
+	if threadID != 0 {
+		data.Add("message_thread_id", strconv.Itoa(threadID))
+	}
+	return data
+}
+
+// Return fully processed requests to be sent to Telegram in order. Media which
+// can't share an album are split into several requests, only the first one
+// carries the caption. What doesn't fit in the caption follows as text
+// messages, and the text of a post without media is shortened to fit in one
+func (tmc *TelegramMessage) ToData(chatID string) ([]TelegramRequest, error) {
+	content, err := tmc.serializeContent(true)
+	if err != nil {
+		return nil, err
+	}
+
+	textData := func(text string) TelegramRequest {
+		data := newRequestData(chatID, tmc.parseMode())
+		data.Add("text", text)
+		return TelegramRequest{EndPoint: SendTypeMessage, Data: data}
+	}
+	if len(tmc.media) == 0 {
+		text, err := tmc.shortenText(content)
 		if err != nil {
-			return data, "", err
+			return nil, err
 		}
+		return []TelegramRequest{textData(text)}, nil
+	}
 
-		result := make([]string, 0)
-		// There's no "text", must add "caption" for the first media instead
-		result = append(result,
-			fmt.Sprintf(`{"type":"%s","media":"%s","caption":"%s","parse_mode":"MarkdownV2"}`,
-				tmc.media[0].MediaType,
-				tmc.media[0].MediaUrl,
-				content))
-
-		// Add the rest of the media to the result
-		for _, media := range tmc.media[1:] {
-			result = append(result,
-				fmt.Sprintf(`{"type":"%s","media":"%s"}`,
-					media.MediaType,
-					media.MediaUrl))
+	content, texts, err := tmc.splitCaption(content)
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]TelegramRequest, 0)
+	for i, group := range groupMedia(tmc.media) {
+		caption := ""
+		if i == 0 {
+			caption = content
 		}
 
-		data.Add("media", "["+strings.Join(result, ",")+"]")
-		return data, SendTypeMediaGroup, nil
+		var request TelegramRequest
+		var err error
+		switch len(group) {
+		case 1:
+			request, err = singleMediaData(chatID, tmc.parseMode(), group[0], caption, tmc.spoiler)
+		default:
+			request, err = mediaGroupData(chatID, tmc.parseMode(), group, caption, tmc.spoiler)
+		}
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	for _, text := range texts {
+		requests = append(requests, textData(text))
 	}
+	return requests, nil
 }
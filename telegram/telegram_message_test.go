@@ -0,0 +1,248 @@
+package telegram_test
+
+import (
+	"encoding/json"
+	"social-2-telego/social"
+	"social-2-telego/telegram"
+	"social-2-telego/utils"
+	"strings"
+	"testing"
+)
+
+func newTestMessage(media []social.ScrapedMedia) *telegram.TelegramMessage {
+	teleMsg := &telegram.TelegramMessage{}
+	return teleMsg.
+		SetContent(func(string) string { return "" }).
+		SetArtistNameAndUsername("@lorem").
+		SetMedia(media).
+		SetPostURL("https://x.com/lorem/status/1")
+}
+
+func TestToDataSplitsIncompatibleMedia(t *testing.T) {
+	media := []social.ScrapedMedia{
+		{MediaType: social.MediaTypePhoto, MediaUrl: "https://example.com/1.jpg"},
+		{MediaType: social.MediaTypeDocument, MediaUrl: "https://example.com/1.pdf"},
+		{MediaType: social.MediaTypeVideo, MediaUrl: "https://example.com/1.mp4"},
+		{MediaType: social.MediaTypeAnimation, MediaUrl: "https://example.com/1.gif"},
+		{MediaType: social.MediaTypeAudio, MediaUrl: "https://example.com/1.mp3"},
+		{MediaType: social.MediaTypeDocument, MediaUrl: "https://example.com/2.pdf"},
+	}
+
+	requests, err := newTestMessage(media).ToData("1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	expected := []telegram.SendType{
+		telegram.SendTypeMediaGroup, // photo + video
+		telegram.SendTypeMediaGroup, // both documents
+		telegram.SendTypeAnimation,
+		telegram.SendTypeAudio,
+	}
+	if len(requests) != len(expected) {
+		t.Fatalf("Expected %d requests, got %d", len(expected), len(requests))
+	}
+	for i, request := range requests {
+		if request.EndPoint != expected[i] {
+			t.Errorf("Request %d: expected %s, got %s", i, expected[i], request.EndPoint)
+		}
+		hasCaption := request.Data.Has("caption") || strings.Contains(request.Data.Get("media"), `"caption"`)
+		if (i == 0) != hasCaption {
+			t.Errorf("Request %d: only the first request should carry the caption", i)
+		}
+	}
+}
+
+func TestToDataChunksLargeAlbums(t *testing.T) {
+	media := make([]social.ScrapedMedia, 0)
+	for range 11 {
+		media = append(media, social.ScrapedMedia{MediaType: social.MediaTypePhoto, MediaUrl: "https://example.com/1.jpg"})
+	}
+
+	requests, err := newTestMessage(media).ToData("1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(requests) != 2 || requests[0].EndPoint != telegram.SendTypeMediaGroup || requests[1].EndPoint != telegram.SendTypePhoto {
+		t.Errorf("Expected a media group followed by a single photo, got %v", requests)
+	}
+}
+
+func TestToDataPostsToTheTopic(t *testing.T) {
+	media := make([]social.ScrapedMedia, 0)
+	for range 11 {
+		media = append(media, social.ScrapedMedia{MediaType: social.MediaTypePhoto, MediaUrl: "https://example.com/1.jpg"})
+	}
+
+	requests, err := newTestMessage(media).ToData("-100123:45")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	for _, request := range requests {
+		if request.Data.Get("chat_id") != "-100123" || request.Data.Get("message_thread_id") != "45" {
+			t.Errorf("Expected every request to go to topic 45 of -100123, got %v", request.Data)
+		}
+	}
+}
+
+func TestToDataRendersAltTexts(t *testing.T) {
+	media := []social.ScrapedMedia{
+		{MediaType: social.MediaTypePhoto, MediaUrl: "https://example.com/1.jpg", AltText: "A fox.\nSleeping"},
+		{MediaType: social.MediaTypePhoto, MediaUrl: "https://example.com/2.jpg"},
+	}
+
+	requests, err := newTestMessage(media).ToData("1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	var items []struct {
+		Caption string `json:"caption"`
+	}
+	if err := json.Unmarshal([]byte(requests[0].Data.Get("media")), &items); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	expected := "\n**>Image descriptions\n>1\\. A fox\\.\n>Sleeping||"
+	if !strings.HasSuffix(items[0].Caption, expected) {
+		t.Errorf("Expected caption to end with %q, got %q", expected, items[0].Caption)
+	}
+}
+
+func TestToDataMovesLongAltTextsOutOfTheCaption(t *testing.T) {
+	media := []social.ScrapedMedia{
+		{MediaType: social.MediaTypePhoto, MediaUrl: "https://example.com/1.jpg", AltText: strings.Repeat("A fox. ", 1000)},
+	}
+
+	requests, err := newTestMessage(media).ToData("1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(requests) != 2 || requests[1].EndPoint != telegram.SendTypeMessage {
+		t.Fatalf("Expected the photo then a text message, got %v", requests)
+	}
+	caption := requests[0].Data.Get("caption")
+	if strings.Contains(caption, "Image description") || utils.TextLength(caption, utils.ParseModeMarkdownV2) > utils.MaxCaptionLength {
+		t.Errorf("Expected a short caption without the alt text, got %q", caption)
+	}
+	text := requests[1].Data.Get("text")
+	if !strings.HasPrefix(text, "**>Image description\n>A fox\\.") || !strings.HasSuffix(text, "…||") {
+		t.Errorf("Expected the truncated alt text, got %q", text)
+	}
+	if length := utils.TextLength(text, utils.ParseModeMarkdownV2); length > utils.MaxMessageLength {
+		t.Errorf("Expected the alt text to fit in a message, got %d characters", length)
+	}
+}
+
+func TestToDataShortensLongTextPosts(t *testing.T) {
+	content := strings.Repeat(`A fox\. [Link](https://example\.com) `, 500)
+	requests, err := newTestMessage(nil).
+		SetContent(func(string) string { return content }).
+		ToData("1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(requests) != 1 || requests[0].EndPoint != telegram.SendTypeMessage {
+		t.Fatalf("Expected a single text message, got %v", requests)
+	}
+	text := requests[0].Data.Get("text")
+	if length := utils.TextLength(text, utils.ParseModeMarkdownV2); length > utils.MaxMessageLength {
+		t.Errorf("Expected the text to fit in a message, got %d characters", length)
+	}
+	if !strings.Contains(text, "…\n[Post](https://x\\.com/lorem/status/1)") {
+		t.Errorf("Expected the cut content then the link to the post, got %q", text[len(text)-200:])
+	}
+}
+
+func TestToDataMarksSpoilers(t *testing.T) {
+	media := []social.ScrapedMedia{
+		{MediaType: social.MediaTypePhoto, MediaUrl: "https://example.com/1.jpg"},
+		{MediaType: social.MediaTypeVideo, MediaUrl: "https://example.com/2.mp4"},
+		{MediaType: social.MediaTypeDocument, MediaUrl: "https://example.com/3.zip"},
+	}
+	requests, err := newTestMessage(media).SetSpoiler(true).ToData("1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	var album []map[string]any
+	if err := json.Unmarshal([]byte(requests[0].Data.Get("media")), &album); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	for i, item := range album {
+		if item["has_spoiler"] != true {
+			t.Errorf("Expected album item %d to have a spoiler", i)
+		}
+	}
+	if requests[1].Data.Get("has_spoiler") != "" {
+		t.Errorf("Expected no spoiler on a document")
+	}
+}
+
+func TestToDataFollowsTheCaptionTemplate(t *testing.T) {
+	caption, err := utils.NewCaptionTemplate(`{{md .Title}} by {{md .Author.DisplayName}}{{range .Hashtags}} \#{{.}}{{end}}`, "")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	requests, err := newTestMessage(nil).
+		SetHashtags("#foo #bar").
+		SetPostInfo("A fox.", "fa", "general").
+		SetCaptionTemplate(caption).
+		ToData("1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if text := requests[0].Data.Get("text"); text != `A fox\. by lorem \#foo \#bar` {
+		t.Errorf("Unexpected caption %q", text)
+	}
+}
+
+func TestToDataWritesHTMLCaptions(t *testing.T) {
+	caption, err := utils.NewCaptionTemplate("", utils.ParseModeHTML)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	media := []social.ScrapedMedia{{MediaType: social.MediaTypePhoto, MediaUrl: "https://example.com/1.jpg"}}
+	requests, err := newTestMessage(media).
+		SetContent(func(string) string { return `1 \< 2 [a\_link](https://example\.com)` }).
+		SetCaptionTemplate(caption).
+		ToData("1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	expected := "<blockquote>1 &lt; 2 <a href=\"https://example.com\">a_link</a></blockquote>\n" +
+		`<a href="https://x.com/lorem/status/1">Post</a> | <a href="https://artistdb.delnegend.com/lorem">lorem</a>`
+	if requests[0].Data.Get("parse_mode") != utils.ParseModeHTML || requests[0].Data.Get("caption") != expected {
+		t.Errorf("Unexpected caption %q", requests[0].Data.Get("caption"))
+	}
+}
+
+func TestToDataLinksToTheArtistDB(t *testing.T) {
+	requests, err := newTestMessage(nil).
+		SetArtistNameAndUsername("@lo/rem Lorem").
+		SetPostInfo("", "x", "").
+		SetArtistDB("https://db.example.com/{source}/{username}?name={display_name}").
+		ToData("1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if text := requests[0].Data.Get("text"); !strings.HasSuffix(text, `[Lorem](https://db\.example\.com/x/lo%2Frem?name\=Lorem)`) {
+		t.Errorf("Unexpected caption %q", text)
+	}
+}
+
+func TestToDataQueryEscapesTheArtistDBDisplayName(t *testing.T) {
+	requests, err := newTestMessage(nil).
+		SetArtistNameAndUsername("@lorem A&B=C").
+		SetArtistDB("https://db.example.com/{username}?name={display_name}").
+		ToData("1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if text := requests[0].Data.Get("text"); !strings.HasSuffix(text, `(https://db\.example\.com/lorem?name\=A%26B%3DC)`) {
+		t.Errorf("Unexpected caption %q", text)
+	}
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// An artist as given to /alias add
+type AliasSpec struct {
+	// The handles of the artist, like "fa:foo"
+	Aliases     []string
+	Username    string
+	DisplayName string
+	// Without their "#"
+	Hashtags []string
+	// The name of the target the posts go to, empty to route them
+	Target string
+	// "+spoiler" hides the media of the posts, "-spoiler" doesn't, nil
+	// when neither is given
+	Spoiler *bool
+}
+
+// Parse `fa:foo x:foo_art => foo "Foo Bar" #comic >nsfw +spoiler`, the handles
+// of an artist followed by its canonical username, its display name, quoted
+// when it has several words, and the defaults of its posts. The display name
+// defaults to the username
+func ParseAlias(s string) (AliasSpec, error) {
+	handles, artist, ok := strings.Cut(s, "=>")
+	if !ok {
+		return AliasSpec{}, fmt.Errorf("missing => between the handles and the artist")
+	}
+
+	spec := AliasSpec{}
+	for _, alias := range strings.Fields(handles) {
+		source, handle, ok := strings.Cut(alias, ":")
+		if !ok || source == "" || handle == "" {
+			return AliasSpec{}, fmt.Errorf("%q isn't a handle like fa:foo", alias)
+		}
+		spec.Aliases = append(spec.Aliases, alias)
+	}
+	if len(spec.Aliases) == 0 {
+		return AliasSpec{}, fmt.Errorf("no handles before =>")
+	}
+
+	fields := strings.Fields(artist)
+	if len(fields) == 0 {
+		return AliasSpec{}, fmt.Errorf("no username after =>")
+	}
+	spec.Username = strings.TrimPrefix(fields[0], "@")
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(artist), fields[0]))
+
+	// the display name is quoted, or runs until the defaults
+	if strings.HasPrefix(rest, `"`) {
+		end := strings.Index(rest[1:], `"`)
+		if end < 0 {
+			return AliasSpec{}, fmt.Errorf("unclosed quote in the display name")
+		}
+		spec.DisplayName = rest[1 : end+1]
+		rest = rest[end+2:]
+	} else {
+		words := strings.Fields(rest)
+		i := slices.IndexFunc(words, func(word string) bool {
+			return strings.HasPrefix(word, "#") || strings.HasPrefix(word, ">") || word == "+spoiler" || word == "-spoiler"
+		})
+		if i < 0 {
+			i = len(words)
+		}
+		spec.DisplayName = strings.Join(words[:i], " ")
+		rest = strings.Join(words[i:], " ")
+	}
+	if spec.DisplayName == "" {
+		spec.DisplayName = spec.Username
+	}
+
+	for _, field := range strings.Fields(rest) {
+		switch {
+		case len(field) > 1 && field[0] == '#':
+			spec.Hashtags = append(spec.Hashtags, field[1:])
+		case len(field) > 1 && field[0] == '>':
+			spec.Target = field[1:]
+		case field == "+spoiler" || field == "-spoiler":
+			spoiler := field[0] == '+'
+			spec.Spoiler = &spoiler
+		default:
+			return AliasSpec{}, fmt.Errorf("%q isn't a #hashtag, a >target or +spoiler", field)
+		}
+	}
+	return spec, nil
+}
@@ -0,0 +1,53 @@
+package utils_test
+
+import (
+	"slices"
+	"social-2-telego/utils"
+	"testing"
+)
+
+func TestParseAlias(t *testing.T) {
+	spec, err := utils.ParseAlias(` fa:foo x:foo_art => @foo "Foo Bar" #comic #wip`)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !slices.Equal(spec.Aliases, []string{"fa:foo", "x:foo_art"}) || spec.Username != "foo" ||
+		spec.DisplayName != "Foo Bar" || !slices.Equal(spec.Hashtags, []string{"comic", "wip"}) {
+		t.Errorf("Unexpected alias %+v", spec)
+	}
+
+	spec, err = utils.ParseAlias(`x:bar => bar Bar Baz #art`)
+	if err != nil || spec.DisplayName != "Bar Baz" || len(spec.Hashtags) != 1 {
+		t.Errorf("Unexpected alias %+v, %v", spec, err)
+	}
+	if spec, err = utils.ParseAlias(`x:bar => bar`); err != nil || spec.DisplayName != "bar" {
+		t.Errorf("Unexpected alias %+v, %v", spec, err)
+	}
+	spec, err = utils.ParseAlias(`x:baz => baz Baz-Qux >nsfw +spoiler #comic`)
+	if err != nil || spec.DisplayName != "Baz-Qux" || spec.Target != "nsfw" || spec.Spoiler == nil || !*spec.Spoiler {
+		t.Errorf("Unexpected alias %+v, %v", spec, err)
+	}
+
+	for _, text := range []string{
+		`fa:foo foo`,
+		`foo => foo`,
+		` => foo`,
+		`fa:foo =>`,
+		`fa:foo => foo "Foo`,
+		`fa:foo => foo "Foo" comic`,
+		`fa:foo => foo #comic +nsfw`,
+	} {
+		if _, err := utils.ParseAlias(text); err == nil {
+			t.Errorf("%s: expected an error", text)
+		}
+	}
+}
+
+func TestMergeHashtags(t *testing.T) {
+	if got := utils.MergeHashtags("#Comic #wip", []string{"comic", "#fox"}); got != "#Comic #wip #fox" {
+		t.Errorf("Unexpected hashtags %q", got)
+	}
+	if got := utils.MergeHashtags("", []string{"fox"}); got != "#fox" {
+		t.Errorf("Unexpected hashtags %q", got)
+	}
+}
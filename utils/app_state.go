@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"net/url"
 	"os"
+	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
@@ -23,19 +24,45 @@ type AppState struct {
 
 	botToken       string
 	artistDBDomain string
+	artistDBAPI    string
+	artistDBToken  string
+	artistDBWrite  string
 	allowedUsers   map[string]interface{}
+	moderators     map[string]interface{}
+	reviewChat     string
+
+	unauthorizedReply         string
+	unauthorizedReplyInterval time.Duration
 
 	targetChannel string
 	numWorker     int
 	faCookieA     string
 	faCookieB     string
 
-	MsgQueue chan IncomingMessage
+	ffmpegPath  string
+	ffprobePath string
+
+	databasePath       string
+	duplicatePolicy    string
+	duplicateThreshold int
+
+	maxRetries     int
+	retryBaseDelay time.Duration
+
+	globalRateLimit float64
+	chatRateLimit   int
+
+	orderTimeout time.Duration
+
+	queueSchedule *SlotSchedule
+	routing       *Routing
+
+	previewPosts bool
 }
 
 // Create a new AppState instance
 func NewAppState() *AppState {
-	return &AppState{
+	appState := &AppState{
 		useWebhook: func() bool {
 			useWebhook := os.Getenv("USE_WEBHOOK")
 			return strings.ToLower(useWebhook) == "true"
@@ -114,6 +141,30 @@ func NewAppState() *AppState {
 
 			return artistDBDomain
 		}(),
+		artistDBAPI: func() string {
+			artistDBAPI := os.Getenv("ARTIST_DB_API")
+			if artistDBAPI == "" {
+				return ""
+			}
+			if _, err := url.ParseRequestURI(artistDBAPI); err != nil || !strings.Contains(artistDBAPI, "{username}") {
+				slog.Error("ARTIST_DB_API must be a URL containing {username}")
+				os.Exit(1)
+			}
+			return artistDBAPI
+		}(),
+		artistDBToken: os.Getenv("ARTIST_DB_API_TOKEN"),
+		artistDBWrite: func() string {
+			artistDBWrite := strings.ToLower(os.Getenv("ARTIST_DB_WRITE"))
+			switch artistDBWrite {
+			case "create", "suggest":
+				return artistDBWrite
+			case "":
+				return ""
+			default:
+				slog.Warn("ARTIST_DB_WRITE must be either create or suggest, unknown artists won't be added")
+				return ""
+			}
+		}(),
 
 		allowedUsers: func() map[string]interface{} {
 			allowedAccounts := os.Getenv("ALLOWED_USERS")
@@ -129,6 +180,39 @@ func NewAppState() *AppState {
 			}
 			return allowedAccountsMap
 		}(),
+		moderators: func() map[string]interface{} {
+			moderators := os.Getenv("MODERATORS")
+			moderatorsMap := make(map[string]interface{})
+			if moderators == "" {
+				return moderatorsMap
+			}
+			for _, account := range strings.Split(moderators, ",") {
+				account = strings.TrimPrefix(strings.TrimSpace(account), "@")
+				moderatorsMap[account] = struct{}{}
+			}
+			return moderatorsMap
+		}(),
+		reviewChat: func() string {
+			reviewChat := os.Getenv("REVIEW_CHAT")
+			if reviewChat != "" && os.Getenv("MODERATORS") == "" {
+				slog.Warn("REVIEW_CHAT is set but MODERATORS isn't, nobody can review posts")
+			}
+			return reviewChat
+		}(),
+
+		unauthorizedReply: os.Getenv("UNAUTHORIZED_REPLY"),
+		unauthorizedReplyInterval: func() time.Duration {
+			unauthorizedReplyInterval := os.Getenv("UNAUTHORIZED_REPLY_INTERVAL")
+			if unauthorizedReplyInterval == "" {
+				return time.Hour
+			}
+			unauthorizedReplyIntervalDur, err := time.ParseDuration(unauthorizedReplyInterval)
+			if err != nil || unauthorizedReplyIntervalDur <= 0 {
+				slog.Warn("UNAUTHORIZED_REPLY_INTERVAL is not a valid duration, defaulting to 1h")
+				return time.Hour
+			}
+			return unauthorizedReplyIntervalDur
+		}(),
 
 		targetChannel: func() string {
 			targetChannel := os.Getenv("TARGET_CHANNEL")
@@ -168,8 +252,166 @@ func NewAppState() *AppState {
 			return faCookieB
 		}(),
 
-		MsgQueue: make(chan IncomingMessage),
+		ffmpegPath:  findExecutable("FFMPEG_PATH", "ffmpeg"),
+		ffprobePath: findExecutable("FFPROBE_PATH", "ffprobe"),
+
+		databasePath: func() string {
+			databasePath := os.Getenv("DATABASE_PATH")
+			if databasePath == "" {
+				slog.Info("DATABASE_PATH is not set, defaulting to data/social-2-telego.db")
+				return "data/social-2-telego.db"
+			}
+			return databasePath
+		}(),
+		duplicatePolicy: func() string {
+			duplicatePolicy := strings.ToLower(os.Getenv("DUPLICATE_POLICY"))
+			switch duplicatePolicy {
+			case "warn", "refuse":
+				return duplicatePolicy
+			case "":
+				return "warn"
+			default:
+				slog.Warn("DUPLICATE_POLICY must be either warn or refuse, defaulting to warn")
+				return "warn"
+			}
+		}(),
+		duplicateThreshold: func() int {
+			duplicateThreshold := os.Getenv("DUPLICATE_THRESHOLD")
+			if duplicateThreshold == "" {
+				return 5
+			}
+			duplicateThresholdInt, err := strconv.Atoi(duplicateThreshold)
+			if err != nil || duplicateThresholdInt < 0 || duplicateThresholdInt > 64 {
+				slog.Warn("DUPLICATE_THRESHOLD must be an integer between 0 and 64, defaulting to 5")
+				return 5
+			}
+			return duplicateThresholdInt
+		}(),
+
+		maxRetries: func() int {
+			maxRetries := os.Getenv("MAX_RETRIES")
+			if maxRetries == "" {
+				return 5
+			}
+			maxRetriesInt, err := strconv.Atoi(maxRetries)
+			if err != nil || maxRetriesInt < 0 {
+				slog.Warn("MAX_RETRIES must be a non-negative integer, defaulting to 5")
+				return 5
+			}
+			return maxRetriesInt
+		}(),
+		retryBaseDelay: func() time.Duration {
+			retryBaseDelay := os.Getenv("RETRY_BASE_DELAY")
+			if retryBaseDelay == "" {
+				return 30 * time.Second
+			}
+			retryBaseDelayDur, err := time.ParseDuration(retryBaseDelay)
+			if err != nil || retryBaseDelayDur <= 0 {
+				slog.Warn("RETRY_BASE_DELAY is not a valid duration, defaulting to 30s")
+				return 30 * time.Second
+			}
+			return retryBaseDelayDur
+		}(),
+
+		globalRateLimit: func() float64 {
+			globalRateLimit := os.Getenv("GLOBAL_RATE_LIMIT")
+			if globalRateLimit == "" {
+				return 30
+			}
+			globalRateLimitFloat, err := strconv.ParseFloat(globalRateLimit, 64)
+			if err != nil || globalRateLimitFloat < 1 {
+				slog.Warn("GLOBAL_RATE_LIMIT must be a number of messages per second of at least 1, defaulting to 30")
+				return 30
+			}
+			return globalRateLimitFloat
+		}(),
+		chatRateLimit: func() int {
+			chatRateLimit := os.Getenv("CHAT_RATE_LIMIT")
+			if chatRateLimit == "" {
+				return 20
+			}
+			chatRateLimitInt, err := strconv.Atoi(chatRateLimit)
+			if err != nil || chatRateLimitInt < 1 {
+				slog.Warn("CHAT_RATE_LIMIT must be a number of messages per minute of at least 1, defaulting to 20")
+				return 20
+			}
+			return chatRateLimitInt
+		}(),
+
+		orderTimeout: func() time.Duration {
+			orderTimeout := os.Getenv("ORDER_TIMEOUT")
+			if orderTimeout == "" {
+				return 2 * time.Minute
+			}
+			orderTimeoutDur, err := time.ParseDuration(orderTimeout)
+			if err != nil || orderTimeoutDur < 0 {
+				slog.Warn("ORDER_TIMEOUT is not a valid duration, defaulting to 2m")
+				return 2 * time.Minute
+			}
+			return orderTimeoutDur
+		}(),
+		queueSchedule: func() *SlotSchedule {
+			queueInterval := os.Getenv("QUEUE_INTERVAL")
+			if queueInterval == "" {
+				return nil
+			}
+			queueIntervalDur, err := time.ParseDuration(queueInterval)
+			if err != nil || queueIntervalDur <= 0 {
+				slog.Warn("QUEUE_INTERVAL is not a valid duration, the posting queue is disabled")
+				return nil
+			}
+			queueWindow := os.Getenv("QUEUE_WINDOW")
+			if queueWindow == "" {
+				queueWindow = "00:00-24:00"
+			}
+			queueSchedule, err := NewSlotSchedule(queueIntervalDur, queueWindow)
+			if err != nil {
+				slog.Warn("QUEUE_WINDOW is not valid, the posting queue is disabled", "err", err)
+				return nil
+			}
+			return queueSchedule
+		}(),
+		previewPosts: func() bool {
+			previewPosts := os.Getenv("PREVIEW_POSTS")
+			return strings.ToLower(previewPosts) == "true"
+		}(),
+	}
+
+	// the targets fall back to TARGET_CHANNEL and the QUEUE_* settings
+	appState.routing = func() *Routing {
+		targetsFile := os.Getenv("TARGETS_FILE")
+		if targetsFile == "" {
+			return legacyRouting(appState.targetChannel, appState.queueSchedule)
+		}
+		routing, err := LoadRouting(targetsFile)
+		if err != nil {
+			slog.Error("TARGETS_FILE is not valid", "err", err)
+			os.Exit(1)
+		}
+		return routing
+	}()
+
+	// roles only exist once someone is named, say so since it's the whole
+	// of Telegram otherwise
+	if len(appState.allowedUsers) == 0 && len(appState.moderators) == 0 {
+		slog.Warn("neither ALLOWED_USERS nor MODERATORS is set, every Telegram user is a moderator and can approve, reject, cancel or replay anyone's posts")
 	}
+	return appState
+}
+
+// Get the path of an executable from an environment variable, or look it up
+// in PATH. Returns an empty string when it can't be found
+func findExecutable(envKey string, name string) string {
+	path := os.Getenv(envKey)
+	if path == "" {
+		path = name
+	}
+	found, err := exec.LookPath(path)
+	if err != nil {
+		slog.Warn(name + " is not found, video post-processing is disabled")
+		return ""
+	}
+	return found
 }
 
 // Get whether the app is using the webhook
@@ -207,19 +449,69 @@ func (c *AppState) GetBotToken() string {
 	return c.botToken
 }
 
-// Get the artistDB domain
+// Get the artistDB domain, a URL with {username} and optionally {display_name}
+// and {source} placeholders
 func (c *AppState) GetArtistDBDomain() string {
 	return c.artistDBDomain
 }
 
+// Get the URL ArtistDB entries are looked up at, with {username} and
+// optionally {source} placeholders. Empty when artists aren't looked up
+func (c *AppState) GetArtistDBAPI() string {
+	return c.artistDBAPI
+}
+
+// Get the token sent to the ArtistDB API, empty when it needs none
+func (c *AppState) GetArtistDBToken() string {
+	return c.artistDBToken
+}
+
+// Get what's done with the artists ArtistDB doesn't know, "create" an entry,
+// "suggest" one to ArtistDB's admins, or nothing when empty
+func (c *AppState) GetArtistDBWrite() string {
+	return c.artistDBWrite
+}
+
 // Check if an account is authorized
 func (c *AppState) IsAuthorized(account string) bool {
-	if len(c.allowedUsers) == 0 {
-		return true
-	}
+	return c.GetRole(account) != RoleNone
+}
+
+// Get what an account may do. Without MODERATORS every authorized account is
+// a moderator, like before roles existed
+func (c *AppState) GetRole(account string) Role {
 	account = strings.TrimPrefix(account, "@")
-	_, ok := c.allowedUsers[account]
-	return ok
+	if _, ok := c.moderators[account]; ok {
+		return RoleModerator
+	}
+	if _, ok := c.allowedUsers[account]; !ok && len(c.allowedUsers) > 0 {
+		return RoleNone
+	}
+	if len(c.moderators) == 0 {
+		return RoleModerator
+	}
+	return RoleContributor
+}
+
+// Get the chat where moderators review the posts of contributors, empty when
+// posts aren't reviewed
+func (c *AppState) GetReviewChat() string {
+	return c.reviewChat
+}
+
+// Whether the posts of an account go through the review chat first
+func (c *AppState) NeedsReview(account string) bool {
+	return c.reviewChat != "" && c.GetRole(account) != RoleModerator
+}
+
+// Get the reply sent to unauthorized users, empty to ignore them silently
+func (c *AppState) GetUnauthorizedReply() string {
+	return c.unauthorizedReply
+}
+
+// Get how long an unauthorized user waits between two replies
+func (c *AppState) GetUnauthorizedReplyInterval() time.Duration {
+	return c.unauthorizedReplyInterval
 }
 
 // Get the target channel
@@ -241,3 +533,78 @@ func (c *AppState) GetFaCookieA() string {
 func (c *AppState) GetFaCookieB() string {
 	return c.faCookieB
 }
+
+// Get the path of ffmpeg, empty if not available
+func (c *AppState) GetFfmpegPath() string {
+	return c.ffmpegPath
+}
+
+// Get the path of ffprobe, empty if not available
+func (c *AppState) GetFfprobePath() string {
+	return c.ffprobePath
+}
+
+// Get the path of the database file
+func (c *AppState) GetDatabasePath() string {
+	return c.databasePath
+}
+
+// Check whether near-duplicate images are refused unless forced, instead of
+// only warning the sender
+func (c *AppState) GetRefuseDuplicates() bool {
+	return c.duplicatePolicy == "refuse"
+}
+
+// Get the maximum number of differing bits for two image hashes to be
+// considered a near-duplicate
+func (c *AppState) GetDuplicateThreshold() int {
+	return c.duplicateThreshold
+}
+
+// Get how many times a job is retried after a retryable error before being
+// moved to the dead-letter list
+func (c *AppState) GetMaxRetries() int {
+	return c.maxRetries
+}
+
+// Get the delay before the first retry, doubled on each following attempt
+func (c *AppState) GetRetryBaseDelay() time.Duration {
+	return c.retryBaseDelay
+}
+
+// Get how many messages per second may be sent across all chats
+func (c *AppState) GetGlobalRateLimit() float64 {
+	return c.globalRateLimit
+}
+
+// Get how many messages per minute may be sent to one group or channel
+func (c *AppState) GetChatRateLimit() int {
+	return c.chatRateLimit
+}
+
+// Get how long a line waits for the lines before it in the same message to be
+// published, 0 disables the ordering
+func (c *AppState) GetOrderTimeout() time.Duration {
+	return c.orderTimeout
+}
+
+// Get the posting slots of a target chat, nil when posts to it are published
+// right away
+func (c *AppState) GetQueueSchedule(chat string) *SlotSchedule {
+	target := c.routing.TargetByChat(chat)
+	if target == nil {
+		return nil
+	}
+	return target.GetQueueSchedule()
+}
+
+// Get the targets and the routes between them
+func (c *AppState) GetRouting() *Routing {
+	return c.routing
+}
+
+// Whether posts are previewed to their sender and only published once they
+// press Publish
+func (c *AppState) GetPreviewPosts() bool {
+	return c.previewPosts
+}
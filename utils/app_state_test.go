@@ -0,0 +1,37 @@
+package utils_test
+
+import (
+	"social-2-telego/utils"
+	"testing"
+)
+
+func TestGetRole(t *testing.T) {
+	t.Setenv("BOT_TOKEN", "token")
+	t.Setenv("ARTIST_DB_DOMAIN", "https://artistdb.example.com/{username}")
+	t.Setenv("ALLOWED_USERS", "alice,@bob")
+	t.Setenv("MODERATORS", "@bob, carol")
+	t.Setenv("REVIEW_CHAT", "-1001")
+	appState := utils.NewAppState()
+
+	cases := map[string]utils.Role{
+		"alice":   utils.RoleContributor,
+		"@bob":    utils.RoleModerator,
+		"carol":   utils.RoleModerator,
+		"mallory": utils.RoleNone,
+	}
+	for account, expected := range cases {
+		if got := appState.GetRole(account); got != expected {
+			t.Errorf("%s: expected role %d, got %d", account, expected, got)
+		}
+	}
+	if !appState.NeedsReview("alice") || appState.NeedsReview("bob") {
+		t.Errorf("Expected only contributors to need a review")
+	}
+
+	// without moderators everyone allowed is one, as before roles existed
+	t.Setenv("MODERATORS", "")
+	t.Setenv("REVIEW_CHAT", "")
+	if got := utils.NewAppState().GetRole("alice"); got != utils.RoleModerator {
+		t.Errorf("Expected alice to be a moderator, got %d", got)
+	}
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Build the link to an artist's page from ARTIST_DB_DOMAIN, filling in its
+// {username}, {display_name} and {source} placeholders. Values are
+// path-escaped before the "?" and query-escaped after it, so a display name
+// with "&" or "=" doesn't break the query string
+func ArtistLink(urlTemplate string, username string, displayName string, source string) string {
+	replacer := func(escape func(string) string) *strings.Replacer {
+		return strings.NewReplacer(
+			"{username}", escape(username),
+			"{display_name}", escape(displayName),
+			"{source}", escape(source),
+		)
+	}
+	path, query, hasQuery := strings.Cut(urlTemplate, "?")
+	link := replacer(url.PathEscape).Replace(path)
+	if hasQuery {
+		link += "?" + replacer(url.QueryEscape).Replace(query)
+	}
+	return link
+}
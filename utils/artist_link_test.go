@@ -0,0 +1,25 @@
+package utils_test
+
+import (
+	"social-2-telego/utils"
+	"testing"
+)
+
+func TestArtistLink(t *testing.T) {
+	cases := []struct {
+		template    string
+		username    string
+		displayName string
+		expected    string
+	}{
+		{"https://db.example.com/{username}", "lorem", "Lorem", "https://db.example.com/lorem"},
+		{"https://db.example.com/{source}/{username}", "lo/rem ipsum", "", "https://db.example.com/x/lo%2Frem%20ipsum"},
+		{"https://db.example.com/{username}?name={display_name}", "lorem", "A&B=C", "https://db.example.com/lorem?name=A%26B%3DC"},
+		{"https://db.example.com/?q={display_name}&src={source}", "lorem", "A+B #1", "https://db.example.com/?q=A%2BB+%231&src=x"},
+	}
+	for _, c := range cases {
+		if link := utils.ArtistLink(c.template, c.username, c.displayName, "x"); link != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.template, c.expected, link)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package utils
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"text/template"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// The parse modes a caption can be written in
+const (
+	ParseModeMarkdownV2 = "MarkdownV2"
+	ParseModeHTML       = "HTML"
+)
+
+// The limits of Telegram, counted in UTF-16 code units of the text once its
+// markup is parsed
+const (
+	MaxCaptionLength = 1024
+	MaxMessageLength = 4096
+)
+
+// The caption of a post when its target doesn't have one
+const (
+	DefaultMarkdownV2Caption = `{{with .Content}}{{quote .}}
+{{end}}[Post]({{md .PostURL}}) \| [{{md .Author.DisplayName}}]({{md .Author.URL}})` +
+		`{{with .Hashtags}} \[{{md (join . " #")}}\]{{end}}{{altTexts .AltTexts}}`
+	DefaultHTMLCaption = `{{with .Content}}{{quote .}}
+{{end}}<a href="{{html .PostURL}}">Post</a> | <a href="{{html .Author.URL}}">{{html .Author.DisplayName}}</a>` +
+		`{{with .Hashtags}} [{{html (join . " #")}}]{{end}}{{altTexts .AltTexts}}`
+)
+
+// The values a caption template is rendered with
+type CaptionData struct {
+	// The text of the post, already formatted for the parse mode
+	Content string
+	PostURL string
+	// The title of the post, empty when the site doesn't have titles
+	Title  string
+	Source string
+	Rating string
+	// Without their "#"
+	Hashtags []string
+	Author   CaptionAuthor
+	// The description of each media, empty for the media without one
+	AltTexts []string
+}
+
+// The artist of a post
+type CaptionAuthor struct {
+	Username    string
+	DisplayName string
+	// The artist's page on ArtistDB
+	URL string
+}
+
+// The layout of a caption, a text/template rendered with CaptionData. Besides
+// the builtin functions, templates can use:
+//
+//	md      escapes a value for MarkdownV2
+//	html    escapes a value for HTML
+//	join    joins a list with a separator
+//	quote   wraps formatted text in a blockquote
+//	altTexts renders the alt texts as a collapsed blockquote, nothing if there's none
+type CaptionTemplate struct {
+	template  *template.Template
+	parseMode string
+}
+
+// Parse a caption template written in a parse mode, MarkdownV2 when it's
+// empty. An empty template is the default caption of the parse mode
+func NewCaptionTemplate(text string, parseMode string) (*CaptionTemplate, error) {
+	switch parseMode {
+	case "", ParseModeMarkdownV2:
+		parseMode = ParseModeMarkdownV2
+		if text == "" {
+			text = DefaultMarkdownV2Caption
+		}
+	case ParseModeHTML:
+		if text == "" {
+			text = DefaultHTMLCaption
+		}
+	default:
+		return nil, fmt.Errorf("NewCaptionTemplate: unknown parse mode %q", parseMode)
+	}
+
+	tmpl, err := template.New("caption").Funcs(captionFuncs(parseMode)).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("NewCaptionTemplate: %w", err)
+	}
+	caption := &CaptionTemplate{template: tmpl, parseMode: parseMode}
+
+	// render a sample post so mistakes like unknown fields show up right away
+	// instead of when a post is published
+	if _, err := caption.Render(sampleCaptionData); err != nil {
+		return nil, fmt.Errorf("NewCaptionTemplate: %w", err)
+	}
+	return caption, nil
+}
+
+// A post exercising every field of CaptionData
+var sampleCaptionData = CaptionData{
+	Content:  "Sample content",
+	PostURL:  "https://x.com/lorem/status/1",
+	Title:    "Sample title",
+	Source:   "x",
+	Rating:   "general",
+	Hashtags: []string{"foo", "bar"},
+	Author:   CaptionAuthor{Username: "lorem", DisplayName: "Lorem", URL: "https://artistdb.example.com/lorem"},
+	AltTexts: []string{"A fox", ""},
+}
+
+// Render the caption of a post
+func (c *CaptionTemplate) Render(data CaptionData) (string, error) {
+	var builder strings.Builder
+	if err := c.template.Execute(&builder, data); err != nil {
+		return "", fmt.Errorf("CaptionTemplate.Render: %w", err)
+	}
+	return builder.String(), nil
+}
+
+// Get the parse mode the caption is written in
+func (c *CaptionTemplate) ParseMode() string {
+	return c.parseMode
+}
+
+// Format the content of a post, scraped as MarkdownV2, for a parse mode
+func FormatContent(content string, parseMode string) string {
+	if parseMode == ParseModeHTML {
+		return markdownV2ToHTML(content)
+	}
+	return content
+}
+
+// The functions of a caption template in a parse mode
+func captionFuncs(parseMode string) template.FuncMap {
+	funcs := template.FuncMap{
+		"md":   func(s string) string { return EscapeSpecialChars(s, `\`) },
+		"html": html.EscapeString,
+		"join": strings.Join,
+	}
+	funcs["altTexts"] = func(altTexts []string) string {
+		block := RenderAltTexts(altTexts, parseMode)
+		if block == "" {
+			return ""
+		}
+		return "\n" + block
+	}
+	switch parseMode {
+	case ParseModeHTML:
+		funcs["quote"] = func(s string) string {
+			return "<blockquote>" + s + "</blockquote>"
+		}
+	default:
+		funcs["quote"] = func(s string) string {
+			return ">" + strings.Join(strings.Split(s, "\n"), "\n>")
+		}
+	}
+	return funcs
+}
+
+// Render alt texts as a collapsed blockquote in a parse mode, empty when
+// there's none
+func RenderAltTexts(altTexts []string, parseMode string) string {
+	switch parseMode {
+	case ParseModeHTML:
+		lines := numberAltTexts(altTexts, html.EscapeString, ".")
+		if len(lines) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("<blockquote expandable><b>%s</b>\n%s</blockquote>", altTextsHeading(altTexts), strings.Join(lines, "\n"))
+	default:
+		escape := func(s string) string { return EscapeSpecialChars(s, `\`) }
+		lines := numberAltTexts(altTexts, escape, `\.`)
+		if len(lines) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("**>%s\n>%s||", altTextsHeading(altTexts), strings.Join(lines, "\n>"))
+	}
+}
+
+// Shorten alt texts so their block fits in a text message, each one gets an
+// equal share of the room
+func TruncateAltTexts(altTexts []string, parseMode string) []string {
+	if TextLength(RenderAltTexts(altTexts, parseMode), parseMode) <= MaxMessageLength {
+		return altTexts
+	}
+
+	described := 0
+	for _, altText := range altTexts {
+		if strings.TrimSpace(altText) != "" {
+			described++
+		}
+	}
+	// leave room for the heading and the numbers
+	share := (MaxMessageLength - 100) / described
+	result := make([]string, 0, len(altTexts))
+	for _, altText := range altTexts {
+		// counted in runes, which is never more than UTF-16 code units
+		if runes := []rune(strings.TrimSpace(altText)); len(runes) > share {
+			altText = string(runes[:share-1]) + "…"
+		}
+		result = append(result, altText)
+	}
+	return result
+}
+
+// Shorten the MarkdownV2 content of a post so its visible text is at most
+// `limit` long, "…" included. Escapes and links are never cut in half, a link
+// which doesn't fit is dropped
+func TruncateContent(content string, limit int) string {
+	if TextLength(content, ParseModeMarkdownV2) <= limit {
+		return content
+	}
+	if limit <= 0 {
+		return ""
+	}
+
+	links := make(map[int]int)
+	for _, match := range markdownLinkRgx.FindAllStringIndex(content, -1) {
+		links[match[0]] = match[1]
+	}
+	// leave room for the ellipsis
+	length, cut := 0, 0
+	for i := 0; i < len(content); {
+		_, size := utf8.DecodeRuneInString(content[i:])
+		end := i + size
+		if linkEnd, ok := links[i]; ok {
+			end = linkEnd
+		} else if content[i] == '\\' && end < len(content) {
+			_, size := utf8.DecodeRuneInString(content[end:])
+			end += size
+		}
+		length += TextLength(content[i:end], ParseModeMarkdownV2)
+		if length > limit-1 {
+			break
+		}
+		i, cut = end, end
+	}
+	return content[:cut] + "…"
+}
+
+// The length of a text as Telegram counts it, in UTF-16 code units once the
+// markup of its parse mode is removed
+func TextLength(text string, parseMode string) int {
+	if parseMode == ParseModeHTML {
+		text = html.UnescapeString(htmlTagRgx.ReplaceAllString(text, ""))
+	} else {
+		text = markdownV2Text(text)
+	}
+
+	return len(utf16.Encode([]rune(text)))
+}
+
+var htmlTagRgx = regexp.MustCompile(`<[^>]*>`)
+
+// The visible text of MarkdownV2, without the escapes, the formatting
+// characters and the URLs of the links
+func markdownV2Text(s string) string {
+	var builder strings.Builder
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case r == '\\' && i+1 < len(runes):
+			i++
+			builder.WriteRune(runes[i])
+		case r == ']' && i+1 < len(runes) && runes[i+1] == '(':
+			// skip the URL of a link, its label was already written
+			for i < len(runes) && runes[i] != ')' {
+				if runes[i] == '\\' {
+					i++
+				}
+				i++
+			}
+		case strings.ContainsRune("_*[]()~`>#+-=|{}.!", r):
+			// the special characters which aren't escaped are markup
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// Escape the alt texts which aren't empty and split them into lines, numbered
+// by the position of their media when there are several
+func numberAltTexts(altTexts []string, escape func(string) string, dot string) []string {
+	lines := make([]string, 0)
+	for i, altText := range altTexts {
+		altText = strings.TrimSpace(altText)
+		if altText == "" {
+			continue
+		}
+		altText = escape(altText)
+		if len(altTexts) > 1 {
+			altText = fmt.Sprintf("%d%s %s", i+1, dot, altText)
+		}
+		lines = append(lines, strings.Split(altText, "\n")...)
+	}
+	return lines
+}
+
+// The heading of the alt texts
+func altTextsHeading(altTexts []string) string {
+	if len(altTexts) > 1 {
+		return "Image descriptions"
+	}
+	return "Image description"
+}
+
+var (
+	markdownLinkRgx   = regexp.MustCompile(`\[((?:\\.|[^\]\\])*)\]\(((?:\\.|[^)\\])*)\)`)
+	markdownEscapeRgx = regexp.MustCompile(`\\(.)`)
+	markdownQuoteRgx  = regexp.MustCompile(`(?m)^>`)
+)
+
+// Convert scraped MarkdownV2 content, which only has escaped characters,
+// links and quote markers, to HTML
+func markdownV2ToHTML(s string) string {
+	text := func(s string) string {
+		s = markdownQuoteRgx.ReplaceAllString(s, "")
+		return html.EscapeString(markdownEscapeRgx.ReplaceAllString(s, "$1"))
+	}
+
+	var builder strings.Builder
+	last := 0
+	for _, match := range markdownLinkRgx.FindAllStringSubmatchIndex(s, -1) {
+		builder.WriteString(text(s[last:match[0]]))
+		label := html.EscapeString(markdownEscapeRgx.ReplaceAllString(s[match[2]:match[3]], "$1"))
+		href := html.EscapeString(markdownEscapeRgx.ReplaceAllString(s[match[4]:match[5]], "$1"))
+		fmt.Fprintf(&builder, `<a href="%s">%s</a>`, href, label)
+		last = match[1]
+	}
+	builder.WriteString(text(s[last:]))
+	return builder.String()
+}
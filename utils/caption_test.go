@@ -0,0 +1,65 @@
+package utils_test
+
+import (
+	"social-2-telego/utils"
+	"testing"
+)
+
+func TestNewCaptionTemplateRendersASample(t *testing.T) {
+	for _, text := range []string{
+		"{{.Content",
+		"{{.Artist}}",
+		"{{quote .Hashtags}}",
+		"{{upper .Title}}",
+	} {
+		if _, err := utils.NewCaptionTemplate(text, ""); err == nil {
+			t.Errorf("%s: expected an error", text)
+		}
+	}
+	if _, err := utils.NewCaptionTemplate("", "Markdown"); err == nil {
+		t.Errorf("Expected an error for an unknown parse mode")
+	}
+	if _, err := utils.NewCaptionTemplate("{{.Title}} {{html .Author.Username}}", utils.ParseModeHTML); err != nil {
+		t.Errorf("Error: %v", err)
+	}
+}
+
+func TestTextLength(t *testing.T) {
+	cases := []struct {
+		text      string
+		parseMode string
+		length    int
+	}{
+		{`Hello\. [Post](https://x\.com/a\)b) \| *bold*`, utils.ParseModeMarkdownV2, 18},
+		{"**>Image description\n>A fox||", utils.ParseModeMarkdownV2, 23},
+		{`<a href="https://x.com">Post</a> &amp; <b>bold</b>`, utils.ParseModeHTML, 11},
+		{"🦊", utils.ParseModeHTML, 2},
+	}
+	for _, c := range cases {
+		if length := utils.TextLength(c.text, c.parseMode); length != c.length {
+			t.Errorf("%q: expected %d, got %d", c.text, c.length, length)
+		}
+	}
+}
+
+func TestTruncateContent(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		limit   int
+		result  string
+	}{
+		{"short", `A fox\.`, 6, `A fox\.`},
+		{"plain", "A fox jumps", 6, "A fox…"},
+		{"escape", `A fox\.\.\.`, 7, `A fox\.…`},
+		{"link", `A [fox](https://x\.com) jumps`, 4, "A …"},
+		{"whole link", `A [fox](https://x\.com) jumps`, 6, `A [fox](https://x\.com)…`},
+		{"emoji", "🦊🦊🦊", 5, "🦊🦊…"},
+		{"nothing", "A fox", 0, ""},
+	}
+	for _, c := range cases {
+		if result := utils.TruncateContent(c.content, c.limit); result != c.result {
+			t.Errorf("%s: expected %q, got %q", c.name, c.result, result)
+		}
+	}
+}
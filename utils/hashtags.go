@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"slices"
+	"strings"
+)
+
+// Add default hashtags, given without their "#", to the hashtags of the
+// input line. The ones already there, whatever their case, aren't repeated
+func MergeHashtags(hashtags string, defaults []string) string {
+	fields := strings.Fields(hashtags)
+	for _, hashtag := range defaults {
+		hashtag = "#" + strings.TrimPrefix(hashtag, "#")
+		if !slices.ContainsFunc(fields, func(field string) bool { return strings.EqualFold(field, hashtag) }) {
+			fields = append(fields, hashtag)
+		}
+	}
+	return strings.Join(fields, " ")
+}
@@ -1,13 +1,26 @@
 package utils
 
+type User struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
 type IncomingMessage struct {
-	MessageID int `json:"message_id"`
-	From      struct {
-		ID       int    `json:"id"`
-		Username string `json:"username"`
-	} `json:"from"`
-	Chat struct {
+	MessageID int  `json:"message_id"`
+	From      User `json:"from"`
+	Chat      struct {
 		ID int `json:"id"`
 	} `json:"chat"`
 	Text string `json:"text"`
+	// The message this one replies to, nil if it's not a reply
+	ReplyToMessage *IncomingMessage `json:"reply_to_message,omitempty"`
+}
+
+// A press of an inline keyboard button
+type CallbackQuery struct {
+	ID   string `json:"id"`
+	From User   `json:"from"`
+	// The message carrying the button, nil if it's too old
+	Message *IncomingMessage `json:"message"`
+	Data    string           `json:"data"`
 }
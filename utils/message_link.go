@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Split a target chat into the chat and the forum topic, "-100123:45" being
+// topic 45 of the supergroup -100123. The topic is 0 when there's none
+func SplitChat(chat string) (string, int) {
+	i := strings.LastIndex(chat, ":")
+	if i < 0 {
+		return chat, 0
+	}
+	thread, err := strconv.Atoi(chat[i+1:])
+	if err != nil || thread <= 0 {
+		return chat, 0
+	}
+	return chat[:i], thread
+}
+
+// Build a t.me link to a message in a channel or supergroup, the chat being
+// either its @handle or its numeric ID, with or without a topic. Returns an
+// empty string for chats that can't be linked to, e.g. private chats
+func MessageLink(chat string, messageID int) string {
+	chat, _ = SplitChat(chat)
+	switch {
+	case strings.HasPrefix(chat, "@"):
+		return fmt.Sprintf("https://t.me/%s/%d", strings.TrimPrefix(chat, "@"), messageID)
+	case strings.HasPrefix(chat, "-100"):
+		return fmt.Sprintf("https://t.me/c/%s/%d", strings.TrimPrefix(chat, "-100"), messageID)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// An error that might go away by itself, e.g. a network error, a 5xx or a
+// Telegram flood wait. `RetryAfter` is set when the server said how long to wait
+type RetryableError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// Mark an error as worth retrying
+func Retryable(err error) error {
+	return &RetryableError{Err: err}
+}
+
+// Mark an error returned with an HTTP status as retryable when the status is
+// 429 or 5xx, otherwise return it as is
+func HTTPStatusError(statusCode int, err error) error {
+	if statusCode == http.StatusTooManyRequests || statusCode >= 500 {
+		return Retryable(err)
+	}
+	return err
+}
+
+// Check whether an error is worth retrying, and how long the server asked to
+// wait if it did. Besides the errors marked as retryable, only the network
+// errors which can go away by themselves are: timeouts, refused or reset
+// connections, and connections closed before the response. A malformed URL or
+// an unsupported scheme is not
+func IsRetryable(err error) (bool, time.Duration) {
+	var retryableErr *RetryableError
+	if errors.As(err, &retryableErr) {
+		return true, retryableErr.RetryAfter
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true, 0
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsTemporary {
+		return true, 0
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true, 0
+	}
+	// only an EOF of the transport means the connection was dropped, a body
+	// may well be empty
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && (errors.Is(urlErr.Err, io.EOF) || errors.Is(urlErr.Err, io.ErrUnexpectedEOF)) {
+		return true, 0
+	}
+	return false, 0
+}
+
+// Turn a non-200 response into an error, retryable when it's worth it
+func UnexpectedStatus(resp *http.Response) error {
+	return HTTPStatusError(resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status))
+}
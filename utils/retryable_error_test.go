@@ -0,0 +1,69 @@
+package utils_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"social-2-telego/utils"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	dial := func(err error) error {
+		return &url.Error{Op: "Get", URL: "https://example.com", Err: &net.OpError{Op: "dial", Net: "tcp", Err: err}}
+	}
+	cases := []struct {
+		name       string
+		err        error
+		retryable  bool
+		retryAfter time.Duration
+	}{
+		{"flood wait", &utils.RetryableError{Err: errors.New("429"), RetryAfter: 3 * time.Second}, true, 3 * time.Second},
+		{"wrapped retryable", fmt.Errorf("scrape: %w", utils.Retryable(errors.New("502"))), true, 0},
+		{"5xx", utils.HTTPStatusError(http.StatusBadGateway, errors.New("502")), true, 0},
+		{"429", utils.HTTPStatusError(http.StatusTooManyRequests, errors.New("429")), true, 0},
+		{"4xx", utils.HTTPStatusError(http.StatusNotFound, errors.New("404")), false, 0},
+		{"client timeout", &url.Error{Op: "Get", URL: "https://example.com", Err: context.DeadlineExceeded}, true, 0},
+		{"dial timeout", dial(os.ErrDeadlineExceeded), true, 0},
+		{"connection refused", dial(os.NewSyscallError("connect", syscall.ECONNREFUSED)), true, 0},
+		{"connection reset", dial(os.NewSyscallError("read", syscall.ECONNRESET)), true, 0},
+		{"connection closed", &url.Error{Op: "Get", URL: "https://example.com", Err: io.EOF}, true, 0},
+		{"temporary DNS failure", dial(&net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}), true, 0},
+		{"unknown host", dial(&net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}), false, 0},
+		{"unsupported scheme", &url.Error{Op: "Get", URL: "ftp://example.com", Err: errors.New(`unsupported protocol scheme "ftp"`)}, false, 0},
+		{"empty body", fmt.Errorf("decode: %w", io.EOF), false, 0},
+		{"plain error", errors.New("no media"), false, 0},
+		{"nil", nil, false, 0},
+	}
+	for _, c := range cases {
+		if retryable, retryAfter := utils.IsRetryable(c.err); retryable != c.retryable || retryAfter != c.retryAfter {
+			t.Errorf("%s: expected %v, %v, got %v, %v", c.name, c.retryable, c.retryAfter, retryable, retryAfter)
+		}
+	}
+}
+
+func TestIsRetryableWithRealRequests(t *testing.T) {
+	// nothing listens on a port which was just closed
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	_, err = http.Get("http://" + address)
+	if retryable, _ := utils.IsRetryable(err); !retryable {
+		t.Errorf("Expected a refused connection to be retryable, got %v", err)
+	}
+
+	_, err = http.Get("http://[::1")
+	if retryable, _ := utils.IsRetryable(err); retryable {
+		t.Errorf("Expected a malformed URL not to be retryable, got %v", err)
+	}
+}
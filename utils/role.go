@@ -0,0 +1,13 @@
+package utils
+
+// What an account may do with the bot
+type Role int
+
+const (
+	// Not allowed to use the bot
+	RoleNone Role = iota
+	// Can submit posts, which moderators review when a review chat is set
+	RoleContributor
+	// Can publish without review, review others' posts and manage the queues
+	RoleModerator
+)
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How schedules are shown back to the user
+const ScheduleFormat = "2006-01-02 15:04 MST"
+
+// Layouts accepted after "at", in the local timezone
+var scheduleLayouts = []string{
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// Check whether an input element is a schedule, e.g. "at 18:00" or "in 3h"
+func IsSchedule(s string) bool {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.HasPrefix(s, "at ") || strings.HasPrefix(s, "in ")
+}
+
+// Parse a schedule relative to `now`. Either an absolute time in the local
+// timezone, "at 2026-10-20 18:00" or "at 18:00" for the next occurrence of that
+// time, or a delay, "in 3h", "in 1h30m" or "in 2d". Times which have already
+// passed are refused
+func ParseSchedule(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	keyword, value, _ := strings.Cut(s, " ")
+	value = strings.TrimSpace(value)
+
+	switch strings.ToLower(keyword) {
+	case "in":
+		delay, err := parseDelay(value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("ParseSchedule: %w", err)
+		}
+		return now.Add(delay), nil
+	case "at":
+		if clock, err := time.ParseInLocation("15:04", value, now.Location()); err == nil {
+			at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+			if !at.After(now) {
+				at = at.AddDate(0, 0, 1)
+			}
+			return at, nil
+		}
+		for _, layout := range scheduleLayouts {
+			if at, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+				if !at.After(now) {
+					return time.Time{}, fmt.Errorf("ParseSchedule: %s has already passed", at.Format(ScheduleFormat))
+				}
+				return at, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("ParseSchedule: %q is not a time like 2026-10-20 18:00 or 18:00", value)
+	default:
+		return time.Time{}, fmt.Errorf("ParseSchedule: a schedule must start with \"at\" or \"in\"")
+	}
+}
+
+// Parse a positive Go duration, also accepting a number of days like "2d"
+func parseDelay(s string) (time.Duration, error) {
+	var delay time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		daysInt, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a delay like 3h, 1h30m or 2d", s)
+		}
+		delay = time.Duration(daysInt) * 24 * time.Hour
+	} else {
+		var err error
+		if delay, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("%q is not a delay like 3h, 1h30m or 2d", s)
+		}
+	}
+	if delay <= 0 {
+		return 0, fmt.Errorf("the delay must be positive")
+	}
+	return delay, nil
+}
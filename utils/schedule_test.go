@@ -0,0 +1,88 @@
+package utils_test
+
+import (
+	"social-2-telego/utils"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	now := time.Date(2026, 10, 19, 20, 0, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		"in 3h":                  now.Add(3 * time.Hour),
+		"in 2d":                  now.Add(48 * time.Hour),
+		"at 2026-10-20 18:00":    time.Date(2026, 10, 20, 18, 0, 0, 0, time.UTC),
+		"AT 21:30":               time.Date(2026, 10, 19, 21, 30, 0, 0, time.UTC),
+		"at 18:00":               time.Date(2026, 10, 20, 18, 0, 0, 0, time.UTC),
+		"at 2026-10-21T09:15:00": time.Date(2026, 10, 21, 9, 15, 0, 0, time.UTC),
+	}
+	for input, expected := range cases {
+		got, err := utils.ParseSchedule(input, now)
+		if err != nil {
+			t.Errorf("%s: %v", input, err)
+			continue
+		}
+		if !got.Equal(expected) {
+			t.Errorf("%s: expected %v, got %v", input, expected, got)
+		}
+	}
+
+	past := []string{"at 2020-01-01 10:00", "at 2026-10-19 18:00", "at 2026-10-19 20:00", "at 2026-10-19"}
+	for _, input := range append(past, "in -1h", "in soon", "at noon", "tomorrow") {
+		if _, err := utils.ParseSchedule(input, now); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+func TestSlotSchedule(t *testing.T) {
+	schedule, err := utils.NewSlotSchedule(90*time.Minute, "09:00-23:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	current := map[time.Time]time.Time{
+		at(19, 8, 59):  {},
+		at(19, 9, 0):   at(19, 9, 0),
+		at(19, 11, 59): at(19, 10, 30),
+		at(19, 22, 59): at(19, 22, 30),
+		at(19, 23, 0):  {},
+	}
+	for now, expected := range current {
+		if got := schedule.Current(now); !got.Equal(expected) {
+			t.Errorf("Current(%v): expected %v, got %v", now, expected, got)
+		}
+	}
+
+	next := map[time.Time]time.Time{
+		at(19, 7, 0):   at(19, 9, 0),
+		at(19, 9, 0):   at(19, 10, 30),
+		at(19, 22, 30): at(20, 9, 0),
+	}
+	for after, expected := range next {
+		if got := schedule.Next(after); !got.Equal(expected) {
+			t.Errorf("Next(%v): expected %v, got %v", after, expected, got)
+		}
+	}
+
+	// a window running past midnight
+	night, err := utils.NewSlotSchedule(time.Hour, "22:00-02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := night.Current(at(20, 1, 30)); !got.Equal(at(20, 1, 0)) {
+		t.Errorf("expected the 01:00 slot, got %v", got)
+	}
+	if got := night.Next(at(20, 1, 30)); !got.Equal(at(20, 22, 0)) {
+		t.Errorf("expected the 22:00 slot, got %v", got)
+	}
+
+	for _, window := range []string{"9-23", "09:00", "09:00-25:00"} {
+		if _, err := utils.NewSlotSchedule(time.Hour, window); err == nil {
+			t.Errorf("%s: expected an error", window)
+		}
+	}
+}
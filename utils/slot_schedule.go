@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Posting slots spread evenly over a daily window, e.g. every 90 minutes
+// between 09:00 and 23:00. The first slot of a day is at the start of the
+// window, a window ending before it starts runs past midnight
+type SlotSchedule struct {
+	Interval time.Duration
+	// Minutes since midnight in the local timezone
+	start, end int
+}
+
+// Create a slot schedule from a window like "09:00-23:00"
+func NewSlotSchedule(interval time.Duration, window string) (*SlotSchedule, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("NewSlotSchedule: the interval must be positive")
+	}
+	startStr, endStr, ok := strings.Cut(window, "-")
+	if !ok {
+		return nil, fmt.Errorf("NewSlotSchedule: %q is not a window like 09:00-23:00", window)
+	}
+	start, err := parseClock(startStr)
+	if err != nil {
+		return nil, fmt.Errorf("NewSlotSchedule: %w", err)
+	}
+	end, err := parseClock(endStr)
+	if err != nil {
+		return nil, fmt.Errorf("NewSlotSchedule: %w", err)
+	}
+	if end <= start {
+		end += 24 * 60
+	}
+	return &SlotSchedule{Interval: interval, start: start, end: end}, nil
+}
+
+// Parse a "15:04" clock time into minutes since midnight, "24:00" included
+func parseClock(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	clock, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time like 09:00", s)
+	}
+	return clock.Hour()*60 + clock.Minute(), nil
+}
+
+// The slots of the window starting on the day of `day`, in order
+func (s *SlotSchedule) slotsOf(day time.Time) []time.Time {
+	year, month, date := day.Date()
+	start := time.Date(year, month, date, 0, s.start, 0, 0, day.Location())
+	end := time.Date(year, month, date, 0, s.end, 0, 0, day.Location())
+
+	slots := make([]time.Time, 0)
+	for slot := start; slot.Before(end); slot = slot.Add(s.Interval) {
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+// Get the slot `now` falls in, zero when it's outside the window. A slot
+// lasts until the next one, or until the end of the window for the last one
+func (s *SlotSchedule) Current(now time.Time) time.Time {
+	// yesterday's window might run past midnight
+	for _, day := range []time.Time{now.AddDate(0, 0, -1), now} {
+		slots := s.slotsOf(day)
+		for i, slot := range slots {
+			end := slot.Add(s.Interval)
+			if i == len(slots)-1 {
+				year, month, date := day.Date()
+				end = time.Date(year, month, date, 0, s.end, 0, 0, day.Location())
+			}
+			if !now.Before(slot) && now.Before(end) {
+				return slot
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// Get the first slot strictly after `after`
+func (s *SlotSchedule) Next(after time.Time) time.Time {
+	for day := after.AddDate(0, 0, -1); ; day = day.AddDate(0, 0, 1) {
+		for _, slot := range s.slotsOf(day) {
+			if slot.After(after) {
+				return slot
+			}
+		}
+	}
+}
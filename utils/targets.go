@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A chat the bot publishes to
+type Target struct {
+	// How the target is referred to in routes and in ">name" markers
+	Name string `json:"name"`
+	// The channel's ID or handle, like TARGET_CHANNEL. A topic of a forum
+	// supergroup is "chat_id:thread_id"
+	Chat string `json:"chat"`
+	// The forum topics posts go to by their first matching hashtag, when the
+	// chat isn't a topic already
+	Topics map[string]int `json:"topics"`
+	// The layout of the caption as a text/template, see CaptionTemplate, the
+	// default one when empty
+	Caption string `json:"caption"`
+	// The parse mode of the caption, MarkdownV2 or HTML
+	ParseMode string `json:"parse_mode"`
+	// Hold posts and publish one per slot, like QUEUE_INTERVAL and
+	// QUEUE_WINDOW for this target only
+	QueueInterval string `json:"queue_interval"`
+	QueueWindow   string `json:"queue_window"`
+
+	queueSchedule   *SlotSchedule
+	captionTemplate *CaptionTemplate
+}
+
+// Sends the posts matching all of its conditions to its targets. An empty
+// condition matches anything, otherwise one of its values must match
+type Route struct {
+	Source   []string `json:"source"`
+	Rating   []string `json:"rating"`
+	Hashtags []string `json:"hashtags"`
+	Targets  []string `json:"targets"`
+}
+
+// The targets and how posts are routed to them. A post goes to the targets of
+// every matching route, or to the default ones when none matches
+type Routing struct {
+	Targets []*Target `json:"targets"`
+	Routes  []Route   `json:"routes"`
+	Default []string  `json:"default"`
+}
+
+// Read the routing from a JSON file and check it
+func LoadRouting(path string) (*Routing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadRouting: %w", err)
+	}
+	routing := &Routing{}
+	if err := json.Unmarshal(data, routing); err != nil {
+		return nil, fmt.Errorf("LoadRouting: %w", err)
+	}
+	if err := routing.validate(); err != nil {
+		return nil, fmt.Errorf("LoadRouting: %w", err)
+	}
+	return routing, nil
+}
+
+// The routing of a single TARGET_CHANNEL, named "default", or no routing at
+// all when it's not set so posts are echoed back to their sender
+func legacyRouting(chat string, queueSchedule *SlotSchedule) *Routing {
+	if chat == "" {
+		return &Routing{}
+	}
+	return &Routing{
+		Targets: []*Target{{Name: "default", Chat: chat, queueSchedule: queueSchedule}},
+		Default: []string{"default"},
+	}
+}
+
+// Check that targets are complete and unique and that routes point to them
+func (r *Routing) validate() error {
+	names := make(map[string]bool)
+	for _, target := range r.Targets {
+		switch {
+		case target.Name == "" || target.Chat == "":
+			return fmt.Errorf("every target needs a name and a chat")
+		case len(target.Topics) > 0 && strings.Contains(target.Chat, ":"):
+			return fmt.Errorf("target %q is a topic already, it can't have topics", target.Name)
+		case names[target.Name]:
+			return fmt.Errorf("target %q is defined twice", target.Name)
+		}
+		names[target.Name] = true
+
+		caption, err := NewCaptionTemplate(target.Caption, target.ParseMode)
+		if err != nil {
+			return fmt.Errorf("target %q: %w", target.Name, err)
+		}
+		target.captionTemplate = caption
+
+		if target.QueueInterval == "" {
+			continue
+		}
+		interval, err := time.ParseDuration(target.QueueInterval)
+		if err != nil {
+			return fmt.Errorf("target %q: %w", target.Name, err)
+		}
+		window := target.QueueWindow
+		if window == "" {
+			window = "00:00-24:00"
+		}
+		if target.queueSchedule, err = NewSlotSchedule(interval, window); err != nil {
+			return fmt.Errorf("target %q: %w", target.Name, err)
+		}
+	}
+
+	for i, route := range r.Routes {
+		if len(route.Targets) == 0 {
+			return fmt.Errorf("route %d has no targets", i+1)
+		}
+		for _, name := range route.Targets {
+			if !names[name] {
+				return fmt.Errorf("route %d: unknown target %q", i+1, name)
+			}
+		}
+	}
+	for _, name := range r.Default {
+		if !names[name] {
+			return fmt.Errorf("default: unknown target %q", name)
+		}
+	}
+	return nil
+}
+
+// Get a target by its name, nil if there's none
+func (r *Routing) Target(name string) *Target {
+	for _, target := range r.Targets {
+		if strings.EqualFold(target.Name, name) {
+			return target
+		}
+	}
+	return nil
+}
+
+// Get a target by its chat, nil if there's none. A topic of the chat of a
+// target belongs to that target
+func (r *Routing) TargetByChat(chat string) *Target {
+	for _, target := range r.Targets {
+		if target.Chat == chat {
+			return target
+		}
+	}
+	chatID, _ := SplitChat(chat)
+	for _, target := range r.Targets {
+		if target.Chat == chatID {
+			return target
+		}
+	}
+	return nil
+}
+
+// Get the chat a post goes to within the target, picking the topic of its
+// first hashtag which has one
+func (t *Target) ChatFor(hashtags []string) string {
+	if _, thread := SplitChat(t.Chat); thread != 0 {
+		return t.Chat
+	}
+	for _, hashtag := range hashtags {
+		hashtag = strings.TrimPrefix(hashtag, "#")
+		for name, thread := range t.Topics {
+			if strings.EqualFold(name, hashtag) {
+				return fmt.Sprintf("%s:%d", t.Chat, thread)
+			}
+		}
+	}
+	return t.Chat
+}
+
+// Get the targets of a post, in the order they first appear in the routes.
+// Hashtags may start with "#" or not
+func (r *Routing) Route(source string, rating string, hashtags []string) []*Target {
+	names := make([]string, 0)
+	for _, route := range r.Routes {
+		if !matchesAny(route.Source, source) || !matchesAny(route.Rating, rating) || !matchesHashtags(route.Hashtags, hashtags) {
+			continue
+		}
+		for _, name := range route.Targets {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		names = r.Default
+	}
+
+	targets := make([]*Target, 0, len(names))
+	for _, name := range names {
+		targets = append(targets, r.Target(name))
+	}
+	return targets
+}
+
+// Whether a value is one of the values of a condition, an empty condition
+// matches anything
+func matchesAny(condition []string, value string) bool {
+	if len(condition) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(condition, func(item string) bool {
+		return strings.EqualFold(item, value)
+	})
+}
+
+// Whether one of the hashtags of a post is one of the hashtags of a condition
+func matchesHashtags(condition []string, hashtags []string) bool {
+	if len(condition) == 0 {
+		return true
+	}
+	for _, hashtag := range hashtags {
+		if matchesAny(condition, strings.TrimPrefix(hashtag, "#")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the posting slots of the target, nil when its posts are published right
+// away
+func (t *Target) GetQueueSchedule() *SlotSchedule {
+	return t.queueSchedule
+}
+
+// Get the layout of the captions of the target, nil for the default one
+func (t *Target) GetCaptionTemplate() *CaptionTemplate {
+	return t.captionTemplate
+}
@@ -0,0 +1,110 @@
+package utils_test
+
+import (
+	"os"
+	"path/filepath"
+	"social-2-telego/utils"
+	"testing"
+)
+
+func writeRouting(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "targets.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	return path
+}
+
+func TestRoute(t *testing.T) {
+	routing, err := utils.LoadRouting(writeRouting(t, `{
+		"targets": [
+			{"name": "sfw", "chat": "@sfw"},
+			{"name": "nsfw", "chat": "@nsfw", "queue_interval": "90m", "queue_window": "09:00-23:00"},
+			{"name": "wip", "chat": "@wip"}
+		],
+		"routes": [
+			{"rating": ["mature", "explicit"], "targets": ["nsfw"]},
+			{"hashtags": ["wip", "sketch"], "targets": ["wip"]},
+			{"source": ["fa"], "hashtags": ["comic"], "targets": ["sfw", "nsfw"]}
+		],
+		"default": ["sfw"]
+	}`))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	names := func(targets []*utils.Target) []string {
+		result := make([]string, 0)
+		for _, target := range targets {
+			result = append(result, target.Name)
+		}
+		return result
+	}
+	cases := []struct {
+		source, rating string
+		hashtags       []string
+		expected       []string
+	}{
+		{"x", "general", nil, []string{"sfw"}},
+		{"fa", "explicit", nil, []string{"nsfw"}},
+		{"x", "general", []string{"#Sketch"}, []string{"wip"}},
+		{"fa", "mature", []string{"#comic", "#wip"}, []string{"nsfw", "wip", "sfw"}},
+		{"x", "general", []string{"#comic"}, []string{"sfw"}},
+	}
+	for _, c := range cases {
+		got := names(routing.Route(c.source, c.rating, c.hashtags))
+		if len(got) != len(c.expected) {
+			t.Errorf("%v: expected %v, got %v", c, c.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.expected[i] {
+				t.Errorf("%v: expected %v, got %v", c, c.expected, got)
+				break
+			}
+		}
+	}
+
+	if routing.Target("NSFW").GetQueueSchedule() == nil || routing.Target("sfw").GetQueueSchedule() != nil {
+		t.Errorf("Expected only the nsfw target to have a posting queue")
+	}
+}
+
+func TestLoadRoutingRejectsUnknownTargets(t *testing.T) {
+	for _, content := range []string{
+		`{"targets": [{"name": "sfw", "chat": "@sfw"}], "routes": [{"targets": ["nsfw"]}]}`,
+		`{"targets": [{"name": "sfw", "chat": "@sfw"}], "default": ["nsfw"]}`,
+		`{"targets": [{"name": "sfw"}]}`,
+		`{"targets": [{"name": "sfw", "chat": "@a"}, {"name": "sfw", "chat": "@b"}]}`,
+		`{"targets": [{"name": "sfw", "chat": "-100123:4", "topics": {"wip": 5}}]}`,
+	} {
+		if _, err := utils.LoadRouting(writeRouting(t, content)); err == nil {
+			t.Errorf("%s: expected an error", content)
+		}
+	}
+}
+
+func TestChatFor(t *testing.T) {
+	target := &utils.Target{Chat: "-100123", Topics: map[string]int{"wip": 5, "comic": 7}}
+	cases := []struct {
+		hashtags []string
+		expected string
+	}{
+		{nil, "-100123"},
+		{[]string{"#foo"}, "-100123"},
+		{[]string{"#foo", "#Comic", "#wip"}, "-100123:7"},
+	}
+	for _, c := range cases {
+		if got := target.ChatFor(c.hashtags); got != c.expected {
+			t.Errorf("%v: expected %q, got %q", c.hashtags, c.expected, got)
+		}
+	}
+
+	routing, err := utils.LoadRouting(writeRouting(t, `{"targets": [{"name": "art", "chat": "-100123"}]}`))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if target := routing.TargetByChat("-100123:7"); target == nil || target.Name != "art" {
+		t.Errorf("Expected a topic to belong to the target of its chat")
+	}
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A token bucket rate limiter, refilled continuously at `rate` tokens per
+// second up to `burst` tokens
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// Create a new TokenBucket instance, starting full
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Block until `n` tokens are available and take them. Callers are served in
+// the order they arrive, taking more than the burst just waits longer. Fails
+// right away when the wait would pass the deadline of the context, and gives
+// the tokens back when the context is done first
+func (b *TokenBucket) WaitN(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.refill(time.Now())
+
+	// reserve the tokens right away, going into debt if needed, so the
+	// next caller waits for its turn after this one
+	b.tokens -= float64(n)
+	wait := time.Duration(0)
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+		b.tokens += float64(n)
+		b.mu.Unlock()
+		return fmt.Errorf("TokenBucket.WaitN: waiting %s would pass the deadline", wait.Round(time.Millisecond))
+	}
+	b.mu.Unlock()
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.refill(time.Now())
+		b.tokens = min(b.burst, b.tokens+float64(n))
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Add the tokens earned since the last refill, the lock must be held
+func (b *TokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
@@ -0,0 +1,79 @@
+package utils_test
+
+import (
+	"context"
+	"errors"
+	"social-2-telego/utils"
+	"testing"
+	"time"
+)
+
+// How long taking `n` tokens blocked
+func timeWaitN(t *testing.T, bucket *utils.TokenBucket, n int) time.Duration {
+	start := time.Now()
+	if err := bucket.WaitN(context.Background(), n); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	return time.Since(start)
+}
+
+func TestTokenBucketAllowsABurst(t *testing.T) {
+	bucket := utils.NewTokenBucket(10, 3)
+
+	for i := range 3 {
+		if waited := timeWaitN(t, bucket, 1); waited > 20*time.Millisecond {
+			t.Errorf("Expected token %d of the burst right away, waited %v", i+1, waited)
+		}
+	}
+	// one token every 100ms once the burst is spent
+	if waited := timeWaitN(t, bucket, 1); waited < 80*time.Millisecond || waited > 200*time.Millisecond {
+		t.Errorf("Expected to wait about 100ms, waited %v", waited)
+	}
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	bucket := utils.NewTokenBucket(20, 2)
+	timeWaitN(t, bucket, 2)
+
+	time.Sleep(120 * time.Millisecond)
+	if waited := timeWaitN(t, bucket, 2); waited > 20*time.Millisecond {
+		t.Errorf("Expected the bucket to be full again, waited %v", waited)
+	}
+	// never more than the burst, however long it rested
+	time.Sleep(300 * time.Millisecond)
+	if waited := timeWaitN(t, bucket, 3); waited < 30*time.Millisecond {
+		t.Errorf("Expected the third token to wait, waited %v", waited)
+	}
+}
+
+func TestTokenBucketGivesUpWithItsContext(t *testing.T) {
+	bucket := utils.NewTokenBucket(1, 1)
+	timeWaitN(t, bucket, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	if err := bucket.WaitN(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the wait to be cancelled, got %v", err)
+	}
+	if waited := time.Since(start); waited > 200*time.Millisecond {
+		t.Errorf("Expected to stop waiting once cancelled, waited %v", waited)
+	}
+	if err := bucket.WaitN(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled context to fail right away, got %v", err)
+	}
+
+	// a wait past the deadline fails right away, the cancelled wait gave its
+	// token back so the next one is due in about a second
+	ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if err := bucket.WaitN(ctx, 1); err == nil || time.Since(start) > 20*time.Millisecond {
+		t.Errorf("Expected to fail right away, got %v after %v", err, time.Since(start))
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := bucket.WaitN(ctx, 1); err != nil {
+		t.Errorf("Expected the token within the deadline, got %v", err)
+	}
+}